@@ -53,11 +53,13 @@ func (self *CapacityConcurrency) Usage(ctx context.Context) float32 {
 	return float32(float64(self.current.Load()) / float64(self.limit))
 }
 
-// Wrap a function in concurrency tracking.
+// Wrap a function in concurrency tracking. Each invocation is counted by its
+// cost as given by CostFromContext.
 func (self *CapacityConcurrency) Wrap(fn Fn) Fn {
 	return func(ctx context.Context) error {
-		self.Add(1)
-		defer self.Done(1)
+		cost := int32(CostFromContext(ctx))
+		self.Add(cost)
+		defer self.Done(cost)
 		var e = fn(ctx)
 		return e
 	}
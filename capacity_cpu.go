@@ -0,0 +1,303 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type OptionCPU func(*CapacityCPU)
+
+func OptionCPUName(name string) OptionCPU {
+	return func(cc *CapacityCPU) {
+		cc.name = name
+	}
+}
+
+// OptionCPUInterval sets how often CPU usage is sampled. The default is 1s and
+// the interval must be greater than zero.
+func OptionCPUInterval(d time.Duration) OptionCPU {
+	return func(cc *CapacityCPU) {
+		cc.interval = d
+	}
+}
+
+// OptionCPUCgroupDir sets the directory of the cgroup v2 files. The default is
+// the directory of the cgroup of the process, as listed in /proc/self/cgroup,
+// beneath /sys/fs/cgroup or /sys/fs/cgroup itself if that directory is not
+// found.
+func OptionCPUCgroupDir(dir string) OptionCPU {
+	return func(cc *CapacityCPU) {
+		cc.cgroupDir = dir
+	}
+}
+
+// OptionCPUProcDir sets the directory of the proc filesystem that is used when
+// cgroup v2 files are not available. The default is /proc.
+func OptionCPUProcDir(dir string) OptionCPU {
+	return func(cc *CapacityCPU) {
+		cc.procDir = dir
+	}
+}
+
+// CapacityCPU reports the CPU utilization of the process as a percentage of
+// the CPU available to it.
+//
+// When running within a cgroup v2 container the usage is read from cpu.stat
+// and the limit is the CPU quota from cpu.max. A quota of max is treated as the
+// number of CPUs available to the Go runtime. Outside of a cgroup the usage is
+// the CPU time of the process from /proc/self/stat as a fraction of the total
+// CPU time of the host from /proc/stat.
+//
+// Usage is sampled by a background goroutine so that calls to Usage never
+// read from the filesystem. The usage reported is that of the most recent
+// sampling interval. Call Close to stop the background goroutine.
+type CapacityCPU struct {
+	name      string
+	interval  time.Duration
+	cgroupDir string
+	procDir   string
+	usage     *atomicFloat32
+	lock      *sync.Mutex
+	source    cpuSource
+	last      cpuSample
+	sampler   *sampler
+	now       func() time.Time
+}
+
+// cpuSample is a cumulative measure of CPU used and of elapsed time. The CPU
+// available between two samples is the change in elapsed time multiplied by
+// the scale of the most recent sample.
+type cpuSample struct {
+	used    float64
+	elapsed float64
+	scale   float64
+}
+
+type cpuSource func(now time.Time) (cpuSample, error)
+
+// NewCapacityCPU detects the available source of CPU usage and begins
+// sampling. An error is returned if the sampling interval is not positive or
+// if neither cgroup v2 nor proc files can be read.
+func NewCapacityCPU(options ...OptionCPU) (*CapacityCPU, error) {
+	c := &CapacityCPU{
+		name:     defaultNameCPU,
+		interval: defaultSampleInterval,
+		procDir:  defaultProcDir,
+		usage:    &atomicFloat32{},
+		lock:     &sync.Mutex{},
+		now:      time.Now,
+	}
+	for _, opt := range options {
+		opt(c)
+	}
+	if c.interval <= 0 {
+		return nil, fmt.Errorf("invalid sampling interval %s", c.interval)
+	}
+	if c.cgroupDir == "" {
+		c.cgroupDir = cgroupDir(defaultCgroupDir, c.procDir)
+	}
+	now := c.now()
+	c.source = c.cgroupSample
+	sample, err := c.source(now)
+	if err != nil {
+		c.source = c.procSample
+		var procErr error
+		sample, procErr = c.source(now)
+		if procErr != nil {
+			return nil, fmt.Errorf("unable to read CPU usage: %w", errors.Join(err, procErr))
+		}
+	}
+	c.last = sample
+	c.sampler = startSampler(c.interval, c.sample)
+	return c, nil
+}
+
+func (self *CapacityCPU) Name(context.Context) string {
+	return self.name
+}
+
+// Usage returns the most recently sampled CPU utilization.
+func (self *CapacityCPU) Usage(context.Context) float32 {
+	return self.usage.Load()
+}
+
+// Close stops the background sampling.
+func (self *CapacityCPU) Close() error {
+	self.sampler.Stop()
+	return nil
+}
+
+// sample updates the usage from the change since the last sample. Errors are
+// ignored so that the last known usage is retained.
+func (self *CapacityCPU) sample() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	current, err := self.source(self.now())
+	if err != nil {
+		return
+	}
+	used := current.used - self.last.used
+	available := (current.elapsed - self.last.elapsed) * current.scale
+	if available <= 0 || used < 0 {
+		return
+	}
+	self.last = current
+	self.usage.Store(float32(used / available))
+}
+
+// cgroupSample reports CPU used from cpu.stat and elapsed wall time scaled by
+// the quota from cpu.max.
+func (self *CapacityCPU) cgroupSample(now time.Time) (cpuSample, error) {
+	stat, err := os.ReadFile(filepath.Join(self.cgroupDir, "cpu.stat"))
+	if err != nil {
+		return cpuSample{}, err
+	}
+	var used float64
+	found := false
+	scanner := bufio.NewScanner(bytes.NewReader(stat))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == "usage_usec" {
+			if used, err = strconv.ParseFloat(fields[1], 64); err != nil {
+				return cpuSample{}, fmt.Errorf("invalid cpu.stat usage_usec: %w", err)
+			}
+			found = true
+		}
+	}
+	if !found {
+		return cpuSample{}, errors.New("cpu.stat is missing usage_usec")
+	}
+	cores, err := self.cgroupCores()
+	if err != nil {
+		return cpuSample{}, err
+	}
+	wall := float64(now.UnixNano()) / float64(time.Microsecond)
+	return cpuSample{used: used, elapsed: wall, scale: cores}, nil
+}
+
+// cgroupCores returns the number of CPUs allowed by cpu.max.
+func (self *CapacityCPU) cgroupCores() (float64, error) {
+	b, err := os.ReadFile(filepath.Join(self.cgroupDir, "cpu.max"))
+	if errors.Is(err, os.ErrNotExist) {
+		return float64(runtime.GOMAXPROCS(0)), nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	fields := strings.Fields(string(b))
+	if len(fields) < 1 || fields[0] == "max" {
+		return float64(runtime.GOMAXPROCS(0)), nil
+	}
+	quota, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cpu.max quota: %w", err)
+	}
+	period := float64(defaultCgroupPeriod)
+	if len(fields) > 1 {
+		if period, err = strconv.ParseFloat(fields[1], 64); err != nil {
+			return 0, fmt.Errorf("invalid cpu.max period: %w", err)
+		}
+	}
+	if quota <= 0 || period <= 0 {
+		return 0, errors.New("invalid cpu.max")
+	}
+	return quota / period, nil
+}
+
+// procSample reports CPU used as the user and system time of the process and
+// elapsed time as the total time of all host CPUs. Both are in clock ticks.
+func (self *CapacityCPU) procSample(time.Time) (cpuSample, error) {
+	procStat, err := os.ReadFile(filepath.Join(self.procDir, "self", "stat"))
+	if err != nil {
+		return cpuSample{}, err
+	}
+	// The second field is the command name which may contain spaces so the
+	// remaining fields are found after its closing parenthesis.
+	end := bytes.LastIndexByte(procStat, ')')
+	if end < 0 {
+		return cpuSample{}, errors.New("invalid self/stat")
+	}
+	fields := strings.Fields(string(procStat[end+1:]))
+	// utime and stime are fields 14 and 15 of the full line.
+	if len(fields) < 13 {
+		return cpuSample{}, errors.New("invalid self/stat")
+	}
+	var used float64
+	for _, f := range fields[11:13] {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return cpuSample{}, fmt.Errorf("invalid self/stat: %w", err)
+		}
+		used = used + v
+	}
+
+	stat, err := os.ReadFile(filepath.Join(self.procDir, "stat"))
+	if err != nil {
+		return cpuSample{}, err
+	}
+	line, _, _ := strings.Cut(string(stat), "\n")
+	fields = strings.Fields(line)
+	if len(fields) < 2 || fields[0] != "cpu" {
+		return cpuSample{}, errors.New("invalid stat")
+	}
+	// Only the first eight values are summed because the guest values that
+	// follow are already included in the user and nice values.
+	if len(fields) > 9 {
+		fields = fields[:9]
+	}
+	var available float64
+	for _, f := range fields[1:] {
+		v, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return cpuSample{}, fmt.Errorf("invalid stat: %w", err)
+		}
+		available = available + v
+	}
+	return cpuSample{used: used, elapsed: available, scale: 1}, nil
+}
+
+// cgroupDir returns the directory of the cgroup v2 of the process beneath the
+// mount. The path of the cgroup is read from self/cgroup in the proc
+// directory. The mount itself is returned if the path cannot be read or does
+// not exist beneath the mount, such as when the process runs in a container
+// whose cgroup is mounted at the root.
+func cgroupDir(mount string, procDir string) string {
+	b, err := os.ReadFile(filepath.Join(procDir, "self", "cgroup"))
+	if err != nil {
+		return mount
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		// The cgroup v2 entry has an ID of 0 and no controllers.
+		path, ok := strings.CutPrefix(scanner.Text(), "0::")
+		if !ok {
+			continue
+		}
+		dir := filepath.Join(mount, filepath.Clean("/"+path))
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+		return mount
+	}
+	return mount
+}
+
+const defaultNameCPU string = "CPU"
+const defaultCgroupDir string = "/sys/fs/cgroup"
+const defaultProcDir string = "/proc"
+const defaultCgroupPeriod int = 100000
+
+var _ Capacity = &CapacityCPU{}
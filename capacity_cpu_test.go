@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestCapacityCPUCgroup(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "cpu.stat"), "usage_usec 1000000\nuser_usec 800000\nsystem_usec 200000\n")
+	writeTestFile(t, filepath.Join(dir, "cpu.max"), "50000 100000\n")
+
+	c, err := NewCapacityCPU(OptionCPUCgroupDir(dir), OptionCPUProcDir(dir), OptionCPUInterval(time.Hour))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	defer c.Close()
+	now := time.Now()
+	c.now = func() time.Time { return now }
+	c.sample()
+
+	ctx := context.Background()
+	now = now.Add(time.Second)
+	writeTestFile(t, filepath.Join(dir, "cpu.stat"), "usage_usec 1250000\n")
+	c.sample()
+	if u := c.Usage(ctx); u < .499 || u > .501 {
+		t.Fatalf("expected usage %f but got %f", .5, u)
+	}
+
+	now = now.Add(time.Second)
+	writeTestFile(t, filepath.Join(dir, "cpu.max"), "200000 100000\n")
+	writeTestFile(t, filepath.Join(dir, "cpu.stat"), "usage_usec 1750000\n")
+	c.sample()
+	if u := c.Usage(ctx); u < .249 || u > .251 {
+		t.Fatalf("expected usage %f but got %f", .25, u)
+	}
+}
+
+func TestCapacityCPUProc(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	procStat := func(utime, stime int) string {
+		return "1 (test (app)) S 0 1 1 0 -1 4194560 100 0 0 0 " + strconv.Itoa(utime) + " " + strconv.Itoa(stime) + " 0 0 20 0 1 0\n"
+	}
+	hostStat := func(user, idle int) string {
+		return "cpu  " + strconv.Itoa(user) + " 0 0 " + strconv.Itoa(idle) + " 0 0 0 0 50 50\ncpu0 1 2 3 4\n"
+	}
+	writeTestFile(t, filepath.Join(dir, "self", "stat"), procStat(10, 10))
+	writeTestFile(t, filepath.Join(dir, "stat"), hostStat(100, 100))
+
+	c, err := NewCapacityCPU(OptionCPUCgroupDir(filepath.Join(dir, "missing")), OptionCPUProcDir(dir), OptionCPUInterval(time.Hour))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	defer c.Close()
+
+	writeTestFile(t, filepath.Join(dir, "self", "stat"), procStat(30, 20))
+	writeTestFile(t, filepath.Join(dir, "stat"), hostStat(150, 250))
+	c.sample()
+	if u := c.Usage(context.Background()); u < .149 || u > .151 {
+		t.Fatalf("expected usage %f but got %f", .15, u)
+	}
+}
+
+func TestCapacityCPUUnavailable(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	_, err := NewCapacityCPU(OptionCPUCgroupDir(dir), OptionCPUProcDir(dir))
+	if err == nil {
+		t.Fatal("expected an error but got nil")
+	}
+}
+
+func TestCapacityCPUInvalidInterval(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "cpu.stat"), "usage_usec 0\n")
+	writeTestFile(t, filepath.Join(dir, "cpu.max"), "max 100000\n")
+	for _, interval := range []time.Duration{0, -time.Second} {
+		_, err := NewCapacityCPU(OptionCPUCgroupDir(dir), OptionCPUInterval(interval))
+		if err == nil {
+			t.Fatalf("expected an error for interval %s but got nil", interval)
+		}
+	}
+}
+
+func TestCgroupDir(t *testing.T) {
+	t.Parallel()
+
+	mount := t.TempDir()
+	writeTestFile(t, filepath.Join(mount, "system.slice", "app.service", "cpu.stat"), "usage_usec 0\n")
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{name: "nested", content: "0::/system.slice/app.service\n", expected: filepath.Join(mount, "system.slice", "app.service")},
+		{name: "hybrid", content: "1:name=systemd:/other\n0::/system.slice/app.service\n", expected: filepath.Join(mount, "system.slice", "app.service")},
+		{name: "root", content: "0::/\n", expected: mount},
+		{name: "not mounted", content: "0::/kubepods/pod\n", expected: mount},
+		{name: "v1 only", content: "4:cpu,cpuacct:/app\n", expected: mount},
+		{name: "missing", expected: mount},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			proc := t.TempDir()
+			if test.content != "" {
+				writeTestFile(t, filepath.Join(proc, "self", "cgroup"), test.content)
+			}
+			if dir := cgroupDir(mount, proc); dir != test.expected {
+				t.Fatalf("expected directory %s but got %s", test.expected, dir)
+			}
+		})
+	}
+}
+
+func writeTestFile(t *testing.T, path string, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+}
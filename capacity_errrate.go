@@ -50,7 +50,9 @@ func OptionErrorRateName(name string) OptionErrorRate {
 //
 // Attempts and errors are always recorded within the same bucket of the window.
 // The rate is then calculated as (errors / attempts) within the window. The
-// current rate is given as the current capacity usage value.
+// current rate is given as the current capacity usage value. Attempts and
+// errors are weighted by the cost of each invocation as given by
+// CostFromContext.
 //
 // The rate calculation is based on a rolling window. The default size of the
 // window is 1s with each bucket representing 10ms. Both of these values can
@@ -82,11 +84,11 @@ func NewCapacityErrorRate(options ...OptionErrorRate) *CapacityErrorRate {
 	c.invocations = rolling.NewTimePolicyConcurrent[int](w, c.bucketDuration)
 	w = rolling.NewPreallocatedWindow[int](c.buckets, c.bucketSizeHint)
 	c.errors = rolling.NewTimePolicyConcurrent[int](w, c.bucketDuration)
-	c.attemptReducer = rolling.Count[int]
+	c.attemptReducer = rolling.Sum[int]
 	if c.minimumPoints > 0 {
-		c.attemptReducer = rolling.MinimumPoints[int](c.minimumPoints, rolling.Count[int])
+		c.attemptReducer = rolling.MinimumPoints[int](c.minimumPoints, rolling.Sum[int])
 	}
-	c.errReducer = rolling.Count[int]
+	c.errReducer = rolling.Sum[int]
 	return c
 }
 
@@ -112,9 +114,10 @@ func (self *CapacityErrorRate) Wrap(fn Fn) Fn {
 		var e error
 		didPanic := true
 		defer func() {
-			self.invocations.Append(ctx, 1)
+			cost := CostFromContext(ctx)
+			self.invocations.Append(ctx, cost)
 			if e != nil || didPanic == true {
-				self.errors.Append(ctx, 1)
+				self.errors.Append(ctx, cost)
 			}
 		}()
 		e = fn(ctx)
@@ -123,6 +126,11 @@ func (self *CapacityErrorRate) Wrap(fn Fn) Fn {
 	}
 }
 
+// RetryAfter returns the length of the rolling window.
+func (self *CapacityErrorRate) RetryAfter(context.Context) time.Duration {
+	return time.Duration(self.buckets) * self.bucketDuration
+}
+
 type errRateWindow interface {
 	Append(ctx context.Context, v int)
 	Reduce(ctx context.Context, r rolling.Reduction[int]) int
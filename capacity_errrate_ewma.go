@@ -0,0 +1,95 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"time"
+)
+
+type OptionErrorRateEWMA func(*CapacityErrorRateEWMA)
+
+func OptionErrorRateEWMAName(name string) OptionErrorRateEWMA {
+	return func(cer *CapacityErrorRateEWMA) {
+		cer.name = name
+	}
+}
+
+// OptionErrorRateEWMAMinimumWeight sets the decayed weight of attempts, which
+// is the cost of recent invocations, below which the usage is reported as zero.
+// This prevents a small number of failures after an idle period from being
+// reported as a high error rate. The default is zero.
+func OptionErrorRateEWMAMinimumWeight(weight float64) OptionErrorRateEWMA {
+	return func(cer *CapacityErrorRateEWMA) {
+		cer.minimumWeight = weight
+	}
+}
+
+// CapacityErrorRateEWMA is an alternative to CapacityErrorRate that calculates
+// the error rate as an exponentially weighted moving average rather than over a
+// rolling window. Each measurement loses half of its influence on the rate
+// after every half-life, so a burst of errors fades gradually rather than
+// leaving the window all at once.
+//
+// Returned errors and panics are both considered in the rate calculation and
+// both attempts and errors are weighted by the cost of each invocation as given
+// by CostFromContext.
+type CapacityErrorRateEWMA struct {
+	name          string
+	halfLife      time.Duration
+	minimumWeight float64
+	avg           *ewma
+}
+
+// NewCapacityErrorRateEWMA uses a half-life of 1s if the given half-life is
+// not positive.
+func NewCapacityErrorRateEWMA(halfLife time.Duration, options ...OptionErrorRateEWMA) *CapacityErrorRateEWMA {
+	halfLife = ewmaHalfLife(halfLife)
+	c := &CapacityErrorRateEWMA{
+		name:     defaultNameErrorRate,
+		halfLife: halfLife,
+		avg:      newEWMA(halfLife),
+	}
+	for _, opt := range options {
+		opt(c)
+	}
+	return c
+}
+
+func (self *CapacityErrorRateEWMA) Name(context.Context) string {
+	return self.name
+}
+
+func (self *CapacityErrorRateEWMA) Usage(ctx context.Context) float32 {
+	errors, attempts := self.avg.Load()
+	if attempts <= 0 || attempts < self.minimumWeight {
+		return 0.0
+	}
+	return float32(errors / attempts)
+}
+
+func (self *CapacityErrorRateEWMA) Wrap(fn Fn) Fn {
+	return func(ctx context.Context) error {
+		var e error
+		didPanic := true
+		defer func() {
+			cost := float64(CostFromContext(ctx))
+			if e != nil || didPanic {
+				self.avg.Add(cost, cost)
+				return
+			}
+			self.avg.Add(0, cost)
+		}()
+		e = fn(ctx)
+		didPanic = false
+		return e
+	}
+}
+
+// RetryAfter returns the half-life.
+func (self *CapacityErrorRateEWMA) RetryAfter(context.Context) time.Duration {
+	return self.halfLife
+}
+
+var _ Capacity = &CapacityErrorRateEWMA{}
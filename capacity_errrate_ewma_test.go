@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCapacityErrorRateEWMA(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+	c := NewCapacityErrorRateEWMA(time.Second)
+	c.avg.now = func() time.Time { return now }
+	success := c.Wrap(func(context.Context) error { return nil })
+	failure := c.Wrap(func(context.Context) error { return errors.New("") })
+	panics := c.Wrap(func(context.Context) error { panic("") })
+
+	if u := c.Usage(ctx); u != 0 {
+		t.Fatalf("expected %f but got %f", 0.0, u)
+	}
+	_ = success(ctx)
+	_ = failure(ctx)
+	func() {
+		defer func() { _ = recover() }()
+		_ = panics(ctx)
+	}()
+	_ = success(CostToContext(ctx, 3))
+	if u := c.Usage(ctx); u < .333 || u > .334 {
+		t.Fatalf("expected %f but got %f", 1.0/3.0, u)
+	}
+
+	now = now.Add(time.Second)
+	for x := 0; x < 3; x = x + 1 {
+		_ = success(ctx)
+	}
+	// Both errors have decayed to one while the six attempts have decayed to
+	// three before three more attempts were added.
+	if u := c.Usage(ctx); u < .166 || u > .167 {
+		t.Fatalf("expected %f but got %f", 1.0/6.0, u)
+	}
+	if d := c.RetryAfter(ctx); d != time.Second {
+		t.Fatalf("expected %s but got %s", time.Second, d)
+	}
+}
+
+func TestCapacityErrorRateEWMAMinimumWeight(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+	c := NewCapacityErrorRateEWMA(time.Second, OptionErrorRateEWMAMinimumWeight(4))
+	c.avg.now = func() time.Time { return now }
+	failure := c.Wrap(func(context.Context) error { return errors.New("") })
+
+	_ = failure(ctx)
+	if u := c.Usage(ctx); u != 0 {
+		t.Fatalf("expected %f but got %f", 0.0, u)
+	}
+	for x := 0; x < 3; x = x + 1 {
+		_ = failure(ctx)
+	}
+	if u := c.Usage(ctx); u != 1 {
+		t.Fatalf("expected %f but got %f", 1.0, u)
+	}
+	// The attempts decay below the minimum weight after one half-life.
+	now = now.Add(time.Second)
+	if u := c.Usage(ctx); u != 0 {
+		t.Fatalf("expected %f but got %f", 0.0, u)
+	}
+}
@@ -0,0 +1,189 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+type OptionKeyed func(*CapacityKeyed)
+
+func OptionKeyedName(name string) OptionKeyed {
+	return func(ck *CapacityKeyed) {
+		ck.name = name
+	}
+}
+
+// OptionKeyedSize sets the maximum number of keys that are tracked at one
+// time. The least recently used key without any invocation in flight is
+// evicted when a new key would exceed the size. The default size is 1024.
+func OptionKeyedSize(size int) OptionKeyed {
+	return func(ck *CapacityKeyed) {
+		ck.size = size
+	}
+}
+
+// CapacityKeyed maintains an independent Capacity for each key extracted from
+// the context, such as a tenant or client identifier. Usage is reported from
+// the Capacity of the calling key so that heavy users are shed before others.
+// This is intended to be combined with a global Capacity that guards the whole
+// process.
+//
+// Each key's Capacity is created on first use by the given factory. The number
+// of keys is bounded and the least recently used key is discarded when the
+// bound is reached. A key is never discarded while any invocation wrapped with
+// its Capacity is in flight so that its counts remain accurate. The number of
+// keys may exceed the bound while every tracked key is in use and returns to
+// the bound as those invocations complete. Reading the usage of a key never
+// creates or discards a Capacity.
+type CapacityKeyed struct {
+	name    string
+	key     func(ctx context.Context) string
+	factory func(key string) Capacity
+	size    int
+	lock    *sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+}
+
+type keyedEntry struct {
+	key      string
+	capacity Capacity
+	active   int
+}
+
+func NewCapacityKeyed(key func(ctx context.Context) string, factory func(key string) Capacity, options ...OptionKeyed) *CapacityKeyed {
+	c := &CapacityKeyed{
+		name:    defaultNameKeyed,
+		key:     key,
+		factory: factory,
+		size:    defaultKeyedSize,
+		lock:    &sync.Mutex{},
+		entries: map[string]*list.Element{},
+		order:   list.New(),
+	}
+	for _, opt := range options {
+		opt(c)
+	}
+	if c.size < 1 {
+		c.size = 1
+	}
+	return c
+}
+
+func (self *CapacityKeyed) Name(ctx context.Context) string {
+	return self.name
+}
+
+// Usage of the Capacity for the key of the current context. A key that is not
+// tracked has no usage.
+func (self *CapacityKeyed) Usage(ctx context.Context) float32 {
+	c, ok := self.lookup(self.key(ctx))
+	if !ok {
+		return 0
+	}
+	return c.Usage(ctx)
+}
+
+// Wrap the function with the Capacity for the key of each invocation. The key
+// is not discarded until the invocation completes.
+func (self *CapacityKeyed) Wrap(fn Fn) Fn {
+	return func(ctx context.Context) error {
+		e := self.acquire(self.key(ctx))
+		defer self.release(e)
+		if wrap, ok := e.capacity.(Wrapper); ok {
+			return wrap.Wrap(fn)(ctx)
+		}
+		return fn(ctx)
+	}
+}
+
+// RetryAfter returns any advice from the Capacity for the key of the current
+// context.
+func (self *CapacityKeyed) RetryAfter(ctx context.Context) time.Duration {
+	c, ok := self.lookup(self.key(ctx))
+	if !ok {
+		return 0
+	}
+	return retryAfter(ctx, c)
+}
+
+// Capacity returns the Capacity for the given key, creating it if needed.
+func (self *CapacityKeyed) Capacity(key string) Capacity {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.entry(key).capacity
+}
+
+// lookup returns the Capacity for the given key without creating it or
+// changing the order of eviction.
+func (self *CapacityKeyed) lookup(key string) (Capacity, bool) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	e, ok := self.entries[key]
+	if !ok {
+		return nil, false
+	}
+	return e.Value.(*keyedEntry).capacity, true
+}
+
+// acquire returns the entry for the given key and marks it as in use.
+func (self *CapacityKeyed) acquire(key string) *keyedEntry {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	e := self.entry(key)
+	e.active = e.active + 1
+	return e
+}
+
+// release marks the end of an invocation and discards any keys that were kept
+// beyond the bound only because they were in use.
+func (self *CapacityKeyed) release(e *keyedEntry) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	e.active = e.active - 1
+	self.evict(self.size)
+}
+
+// entry returns the entry for the given key, creating it if needed. The lock
+// must be held.
+func (self *CapacityKeyed) entry(key string) *keyedEntry {
+	if e, ok := self.entries[key]; ok {
+		self.order.MoveToFront(e)
+		return e.Value.(*keyedEntry)
+	}
+	self.evict(self.size - 1)
+	e := &keyedEntry{key: key, capacity: self.factory(key)}
+	self.entries[key] = self.order.PushFront(e)
+	return e
+}
+
+// evict discards the least recently used keys without any invocation in flight
+// until no more than the given number of keys remain. The lock must be held.
+func (self *CapacityKeyed) evict(size int) {
+	for element := self.order.Back(); element != nil && self.order.Len() > size; {
+		previous := element.Prev()
+		if e := element.Value.(*keyedEntry); e.active < 1 {
+			self.order.Remove(element)
+			delete(self.entries, e.key)
+		}
+		element = previous
+	}
+}
+
+// Len returns the number of keys currently tracked.
+func (self *CapacityKeyed) Len() int {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.order.Len()
+}
+
+const defaultNameKeyed string = "KEYED"
+const defaultKeyedSize int = 1024
+
+var _ Capacity = &CapacityKeyed{}
+var _ Wrapper = &CapacityKeyed{}
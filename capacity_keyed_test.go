@@ -0,0 +1,160 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"testing"
+)
+
+type testKeyedCtxKey struct{}
+
+func testKeyedKey(ctx context.Context) string {
+	k, _ := ctx.Value(testKeyedCtxKey{}).(string)
+	return k
+}
+
+func TestCapacityKeyedUsage(t *testing.T) {
+	t.Parallel()
+
+	c := NewCapacityKeyed(testKeyedKey, func(key string) Capacity {
+		return NewCapacityConcurrency(2)
+	})
+	ctxA := context.WithValue(context.Background(), testKeyedCtxKey{}, "a")
+	ctxB := context.WithValue(context.Background(), testKeyedCtxKey{}, "b")
+
+	var usageA, usageB float32
+	err := c.Wrap(func(ctx context.Context) error {
+		usageA = c.Usage(ctx)
+		usageB = c.Usage(ctxB)
+		return nil
+	})(ctxA)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if usageA != .5 {
+		t.Fatalf("expected usage %f but got %f", .5, usageA)
+	}
+	if usageB != 0 {
+		t.Fatalf("expected usage %f but got %f", 0.0, usageB)
+	}
+	if u := c.Usage(ctxA); u != 0 {
+		t.Fatalf("expected usage %f but got %f", 0.0, u)
+	}
+}
+
+func TestCapacityKeyedEviction(t *testing.T) {
+	t.Parallel()
+
+	created := map[string]int{}
+	c := NewCapacityKeyed(testKeyedKey, func(key string) Capacity {
+		created[key] = created[key] + 1
+		return NewCapacityConcurrency(1)
+	}, OptionKeyedSize(2))
+
+	c.Capacity("a")
+	c.Capacity("b")
+	c.Capacity("a")
+	c.Capacity("c")
+	if c.Len() != 2 {
+		t.Fatalf("expected %d keys but got %d", 2, c.Len())
+	}
+	c.Capacity("a")
+	if created["a"] != 1 {
+		t.Fatalf("expected recently used key to be retained but it was created %d times", created["a"])
+	}
+	c.Capacity("b")
+	if created["b"] != 2 {
+		t.Fatalf("expected least recently used key to be evicted but it was created %d times", created["b"])
+	}
+}
+
+func TestCapacityKeyedUsageReadOnly(t *testing.T) {
+	t.Parallel()
+
+	created := 0
+	c := NewCapacityKeyed(testKeyedKey, func(key string) Capacity {
+		created = created + 1
+		return NewCapacityConcurrency(1)
+	}, OptionKeyedSize(1))
+	c.Capacity("a")
+	ctxB := context.WithValue(context.Background(), testKeyedCtxKey{}, "b")
+
+	if u := c.Usage(ctxB); u != 0 {
+		t.Fatalf("expected usage %f but got %f", 0.0, u)
+	}
+	if d := c.RetryAfter(ctxB); d != 0 {
+		t.Fatalf("expected no advice but got %s", d)
+	}
+	if created != 1 || c.Len() != 1 {
+		t.Fatalf("expected %d key but got %d created and %d tracked", 1, created, c.Len())
+	}
+	c.Capacity("a")
+	if created != 1 {
+		t.Fatalf("expected the key to be retained but it was created %d times", created)
+	}
+}
+
+func TestCapacityKeyedEvictionInFlight(t *testing.T) {
+	t.Parallel()
+
+	c := NewCapacityKeyed(testKeyedKey, func(key string) Capacity {
+		return NewCapacityConcurrency(1)
+	}, OptionKeyedSize(1))
+	ctxA := context.WithValue(context.Background(), testKeyedCtxKey{}, "a")
+
+	var usage float32
+	var length int
+	err := c.Wrap(func(ctx context.Context) error {
+		c.Capacity("b")
+		c.Capacity("c")
+		usage = c.Usage(ctxA)
+		length = c.Len()
+		return nil
+	})(ctxA)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if usage != 1 {
+		t.Fatalf("expected the in-flight key to keep usage %f but got %f", 1.0, usage)
+	}
+	if length != 2 {
+		t.Fatalf("expected %d keys while in flight but got %d", 2, length)
+	}
+	if c.Len() != 1 {
+		t.Fatalf("expected %d key once drained but got %d", 1, c.Len())
+	}
+	if u := c.Usage(ctxA); u != 0 {
+		t.Fatalf("expected usage %f but got %f", 0.0, u)
+	}
+}
+
+func TestCapacityKeyedShedder(t *testing.T) {
+	t.Parallel()
+
+	c := NewCapacityKeyed(testKeyedKey, func(key string) Capacity {
+		return NewCapacityConcurrency(1)
+	})
+	shed := NewShedder(
+		OptionShedderRejectionRate(NewRejectionRateCurveIdentity(NewFailureProbabilityCurveIdentity(c))),
+	)
+	ctxA := context.WithValue(context.Background(), testKeyedCtxKey{}, "a")
+	ctxB := context.WithValue(context.Background(), testKeyedCtxKey{}, "b")
+
+	var inner, other error
+	err := shed.Do(ctxA, func(ctx context.Context) error {
+		inner = shed.Do(ctxA, func(ctx context.Context) error { return nil })
+		other = shed.Do(ctxB, func(ctx context.Context) error { return nil })
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if inner == nil {
+		t.Fatal("expected the saturated key to be rejected")
+	}
+	if other != nil {
+		t.Fatalf("got unexpected error for another key: %s", other)
+	}
+}
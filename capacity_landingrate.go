@@ -39,7 +39,8 @@ func OptionLandingrateName(name string) OptionLandingRate {
 
 // CapacityLandingRate considers the number of method invocations within a window of
 // time that have begun. Note that this counts all attempts to invoke a method
-// and does not distinguish success or failure.
+// and does not distinguish success or failure. Each invocation is counted by
+// its cost as given by CostFromContext.
 //
 // The rate calculation is based on a rolling window. The default size of the
 // window is 1s with each bucket representing 10ms. Both of these values can
@@ -74,17 +75,22 @@ func (self *CapacityLandingRate) Name(context.Context) string {
 }
 
 func (self *CapacityLandingRate) Usage(ctx context.Context) float32 {
-	total := self.invocations.Reduce(ctx, rolling.Count[int])
+	total := self.invocations.Reduce(ctx, rolling.Sum[int])
 	return float32(float64(total) / float64(self.limit))
 }
 
 func (self *CapacityLandingRate) Wrap(fn Fn) Fn {
 	return func(ctx context.Context) error {
-		self.invocations.Append(ctx, 1)
+		self.invocations.Append(ctx, CostFromContext(ctx))
 		return fn(ctx)
 	}
 }
 
+// RetryAfter returns the length of the rolling window.
+func (self *CapacityLandingRate) RetryAfter(context.Context) time.Duration {
+	return time.Duration(self.buckets) * self.bucketDuration
+}
+
 type landingRateWindow interface {
 	Append(ctx context.Context, v int)
 	Reduce(ctx context.Context, r rolling.Reduction[int]) int
@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"time"
+)
+
+type OptionLandingRateEWMA func(*CapacityLandingRateEWMA)
+
+func OptionLandingRateEWMAName(name string) OptionLandingRateEWMA {
+	return func(clr *CapacityLandingRateEWMA) {
+		clr.name = name
+	}
+}
+
+// CapacityLandingRateEWMA is an alternative to CapacityLandingRate that
+// estimates the number of invocations that begin each second using an
+// exponentially weighted moving average rather than counting them within a
+// rolling window. Each invocation loses half of its influence on the rate after
+// every half-life.
+//
+// The limit is the number of invocations per second, which matches the default
+// one second window of CapacityLandingRate. Each invocation is counted by its
+// cost as given by CostFromContext.
+type CapacityLandingRateEWMA struct {
+	name     string
+	limit    int
+	halfLife time.Duration
+	avg      *ewma
+}
+
+// NewCapacityLandingRateEWMA uses a half-life of 1s if the given half-life is
+// not positive.
+func NewCapacityLandingRateEWMA(limit int, halfLife time.Duration, options ...OptionLandingRateEWMA) *CapacityLandingRateEWMA {
+	halfLife = ewmaHalfLife(halfLife)
+	c := &CapacityLandingRateEWMA{
+		name:     defaultNameLandingRate,
+		limit:    limit,
+		halfLife: halfLife,
+		avg:      newEWMA(halfLife),
+	}
+	for _, opt := range options {
+		opt(c)
+	}
+	return c
+}
+
+func (self *CapacityLandingRateEWMA) Name(context.Context) string {
+	return self.name
+}
+
+// Usage converts the decayed count of invocations to a rate per second. The
+// decayed count of a steady rate settles at the rate multiplied by the time
+// constant of the average.
+func (self *CapacityLandingRateEWMA) Usage(ctx context.Context) float32 {
+	_, count := self.avg.Load()
+	tau := self.avg.tau / float64(time.Second)
+	return float32(count / tau / float64(self.limit))
+}
+
+func (self *CapacityLandingRateEWMA) Wrap(fn Fn) Fn {
+	return func(ctx context.Context) error {
+		self.avg.Add(0, float64(CostFromContext(ctx)))
+		return fn(ctx)
+	}
+}
+
+// RetryAfter returns the half-life.
+func (self *CapacityLandingRateEWMA) RetryAfter(context.Context) time.Duration {
+	return self.halfLife
+}
+
+var _ Capacity = &CapacityLandingRateEWMA{}
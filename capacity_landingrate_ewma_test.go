@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCapacityLandingRateEWMA(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+	c := NewCapacityLandingRateEWMA(100, time.Second)
+	c.avg.now = func() time.Time { return now }
+	fn := c.Wrap(func(context.Context) error { return nil })
+
+	if u := c.Usage(ctx); u != 0 {
+		t.Fatalf("expected %f but got %f", 0.0, u)
+	}
+	// A steady rate of 50 invocations per second settles at half of the limit.
+	for x := 0; x < 5000; x = x + 1 {
+		now = now.Add(20 * time.Millisecond)
+		_ = fn(ctx)
+	}
+	if u := c.Usage(ctx); u < .48 || u > .52 {
+		t.Fatalf("expected %f but got %f", .5, u)
+	}
+	// Doubling the cost of each invocation doubles the rate.
+	for x := 0; x < 5000; x = x + 1 {
+		now = now.Add(20 * time.Millisecond)
+		_ = fn(CostToContext(ctx, 2))
+	}
+	if u := c.Usage(ctx); u < .98 || u > 1.02 {
+		t.Fatalf("expected %f but got %f", 1.0, u)
+	}
+}
+
+func TestCapacityLandingRateEWMAHalfLife(t *testing.T) {
+	t.Parallel()
+
+	for _, halfLife := range []time.Duration{0, -time.Second} {
+		c := NewCapacityLandingRateEWMA(1, halfLife)
+		if c.halfLife != defaultEWMAHalfLife {
+			t.Fatalf("expected half-life %s but got %s", defaultEWMAHalfLife, c.halfLife)
+		}
+		ctx := context.Background()
+		_ = c.Wrap(func(ctx context.Context) error { return nil })(ctx)
+		if u := c.Usage(ctx); u <= 0 {
+			t.Fatalf("expected a positive usage but got %f", u)
+		}
+	}
+}
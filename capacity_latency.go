@@ -21,6 +21,38 @@ func OptionLatencyReduction(r LatencyReduction) OptionLatency {
 	}
 }
 
+// OptionLatencyPercentile replaces the reduction with a percentile, given in
+// the form 90.0 or 99.9, that is calculated from a quantile sketch kept for
+// each bucket of the window. Unlike a percentile LatencyReduction, the sketch
+// does not keep every measurement and does not sort the window so memory use
+// is bounded regardless of the request rate. Percentiles are accurate to
+// within 1% of the true value.
+func OptionLatencyPercentile(perc float64) OptionLatency {
+	return func(cl *CapacityLatency) {
+		cl.percentile = perc
+	}
+}
+
+// OptionLatencyP50 is OptionLatencyPercentile(50).
+func OptionLatencyP50() OptionLatency {
+	return OptionLatencyPercentile(50)
+}
+
+// OptionLatencyP90 is OptionLatencyPercentile(90).
+func OptionLatencyP90() OptionLatency {
+	return OptionLatencyPercentile(90)
+}
+
+// OptionLatencyP99 is OptionLatencyPercentile(99).
+func OptionLatencyP99() OptionLatency {
+	return OptionLatencyPercentile(99)
+}
+
+// OptionLatencyP999 is OptionLatencyPercentile(99.9).
+func OptionLatencyP999() OptionLatency {
+	return OptionLatencyPercentile(99.9)
+}
+
 // OptionLatencyMeasurePanics modifies the capacity to capture latency for
 // executions that resulted in a panic in addition to executions that exit
 // normally. The default value is false.
@@ -65,7 +97,8 @@ func OptionLatencyName(name string) OptionLatency {
 //
 // By default, latency is calculated by taking an average of method invocation
 // time within the window. You can provide an alternative calculation using the
-// OptionCapacityLatencyReduction option.
+// OptionCapacityLatencyReduction option or a sketch based percentile using
+// OptionLatencyPercentile.
 //
 // The latency calculation is based on a rolling window. The default size of the
 // window is 1s with each bucket representing 10ms. Both of these values can
@@ -79,6 +112,8 @@ type CapacityLatency struct {
 	bucketSizeHint int
 	minimumPoints  int
 	reduction      LatencyReduction
+	percentile     float64
+	sketch         *latencySketchWindow
 	measurePanics  bool
 }
 
@@ -99,6 +134,10 @@ func NewCapacityLatency(limit time.Duration, options ...OptionLatency) *Capacity
 	for _, option := range options {
 		option(c)
 	}
+	if c.percentile > 0 {
+		c.sketch = newLatencySketchWindow(c.buckets, c.bucketDuration)
+		return c
+	}
 	w := rolling.NewPreallocatedWindow[time.Duration](c.buckets, c.bucketSizeHint)
 	c.window = rolling.NewTimePolicyConcurrent[time.Duration](w, c.bucketDuration)
 	return c
@@ -110,14 +149,27 @@ func (self *CapacityLatency) Name(context.Context) string {
 
 // Append adds a latency measure to the underlying window.
 func (self *CapacityLatency) Append(ctx context.Context, v time.Duration) {
+	if self.sketch != nil {
+		self.sketch.Append(ctx, v)
+		return
+	}
 	self.window.Append(ctx, v)
 }
 
 func (self *CapacityLatency) Usage(ctx context.Context) float32 {
-	value := self.window.Reduce(ctx, self.reduction)
+	value := self.Latency(ctx)
 	return float32(value.Seconds() / self.limit.Seconds())
 }
 
+// Latency returns the reduction of the current window, such as the average or
+// a percentile, without converting it to a usage value.
+func (self *CapacityLatency) Latency(ctx context.Context) time.Duration {
+	if self.sketch != nil {
+		return self.sketch.Quantile(ctx, self.percentile/100)
+	}
+	return self.window.Reduce(ctx, self.reduction)
+}
+
 func (self *CapacityLatency) Wrap(fn Fn) Fn {
 	return func(ctx context.Context) error {
 		start := time.Now()
@@ -136,6 +188,11 @@ func (self *CapacityLatency) Wrap(fn Fn) Fn {
 	}
 }
 
+// RetryAfter returns the length of the rolling window.
+func (self *CapacityLatency) RetryAfter(context.Context) time.Duration {
+	return time.Duration(self.buckets) * self.bucketDuration
+}
+
 type LatencyReduction = rolling.Reduction[time.Duration]
 
 type latencyWindow interface {
@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"time"
+)
+
+type OptionLatencyEWMA func(*CapacityLatencyEWMA)
+
+func OptionLatencyEWMAName(name string) OptionLatencyEWMA {
+	return func(cl *CapacityLatencyEWMA) {
+		cl.name = name
+	}
+}
+
+func OptionLatencyEWMAMeasurePanics(v bool) OptionLatencyEWMA {
+	return func(cl *CapacityLatencyEWMA) {
+		cl.measurePanics = v
+	}
+}
+
+// CapacityLatencyEWMA is an alternative to CapacityLatency that calculates the
+// latency as an exponentially weighted moving average of invocation time
+// rather than reducing a rolling window. Each measurement loses half of its
+// influence on the average after every half-life.
+//
+// Only the average is available. Use CapacityLatency with a percentile option
+// to track tail latency.
+type CapacityLatencyEWMA struct {
+	name          string
+	limit         time.Duration
+	halfLife      time.Duration
+	avg           *ewma
+	measurePanics bool
+}
+
+// NewCapacityLatencyEWMA uses a half-life of 1s if the given half-life is
+// not positive.
+func NewCapacityLatencyEWMA(limit time.Duration, halfLife time.Duration, options ...OptionLatencyEWMA) *CapacityLatencyEWMA {
+	halfLife = ewmaHalfLife(halfLife)
+	c := &CapacityLatencyEWMA{
+		name:          defaultNameLatency,
+		limit:         limit,
+		halfLife:      halfLife,
+		avg:           newEWMA(halfLife),
+		measurePanics: false,
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+func (self *CapacityLatencyEWMA) Name(context.Context) string {
+	return self.name
+}
+
+// Append adds a latency measure to the average.
+func (self *CapacityLatencyEWMA) Append(ctx context.Context, v time.Duration) {
+	self.avg.Add(float64(v), 1)
+}
+
+func (self *CapacityLatencyEWMA) Usage(ctx context.Context) float32 {
+	value := self.Latency(ctx)
+	return float32(value.Seconds() / self.limit.Seconds())
+}
+
+// Latency returns the current average without converting it to a usage value.
+func (self *CapacityLatencyEWMA) Latency(ctx context.Context) time.Duration {
+	total, count := self.avg.Load()
+	if count <= 0 {
+		return 0
+	}
+	return time.Duration(total / count)
+}
+
+func (self *CapacityLatencyEWMA) Wrap(fn Fn) Fn {
+	return func(ctx context.Context) error {
+		start := time.Now()
+		if self.measurePanics {
+			defer func() {
+				d := time.Since(start)
+				self.Append(ctx, d)
+			}()
+		}
+		e := fn(ctx)
+		if !self.measurePanics {
+			d := time.Since(start)
+			self.Append(ctx, d)
+		}
+		return e
+	}
+}
+
+// RetryAfter returns the half-life.
+func (self *CapacityLatencyEWMA) RetryAfter(context.Context) time.Duration {
+	return self.halfLife
+}
+
+var _ Capacity = &CapacityLatencyEWMA{}
@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCapacityLatencyEWMA(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+	c := NewCapacityLatencyEWMA(100*time.Millisecond, time.Second)
+	c.avg.now = func() time.Time { return now }
+
+	if u := c.Usage(ctx); u != 0 {
+		t.Fatalf("expected %f but got %f", 0.0, u)
+	}
+	c.Append(ctx, 50*time.Millisecond)
+	c.Append(ctx, 150*time.Millisecond)
+	if l := c.Latency(ctx); l != 100*time.Millisecond {
+		t.Fatalf("expected %s but got %s", 100*time.Millisecond, l)
+	}
+	if u := c.Usage(ctx); u < .999 || u > 1.001 {
+		t.Fatalf("expected %f but got %f", 1.0, u)
+	}
+	// After one half-life the two earlier measurements together carry the same
+	// weight as the single new one.
+	now = now.Add(time.Second)
+	c.Append(ctx, 10*time.Millisecond)
+	if l := c.Latency(ctx); l < 54*time.Millisecond || l > 56*time.Millisecond {
+		t.Fatalf("expected %s but got %s", 55*time.Millisecond, l)
+	}
+}
+
+func TestCapacityLatencyEWMAMeasurePanics(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := NewCapacityLatencyEWMA(time.Second, time.Second, OptionLatencyEWMAMeasurePanics(true))
+	fn := c.Wrap(func(context.Context) error {
+		time.Sleep(time.Millisecond)
+		panic("")
+	})
+	func() {
+		defer func() { _ = recover() }()
+		_ = fn(ctx)
+	}()
+	if l := c.Latency(ctx); l < time.Millisecond {
+		t.Fatalf("expected at least %s but got %s", time.Millisecond, l)
+	}
+}
@@ -94,3 +94,19 @@ func BenchmarkLatencyPercentile(b *testing.B) {
 		})
 	}
 }
+
+func TestCapacityLatencyPercentile(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c := NewCapacityLatency(time.Second, OptionLatencyP90(), OptionLatencyWindowBuckets(1), OptionLatencyBucketDuration(time.Hour))
+	for x := 1; x <= 100; x = x + 1 {
+		c.Append(ctx, time.Duration(x)*10*time.Millisecond)
+	}
+	if l := c.Latency(ctx); l < 891*time.Millisecond || l > 909*time.Millisecond {
+		t.Fatalf("expected %s but got %s", 900*time.Millisecond, l)
+	}
+	if u := c.Usage(ctx); u < .89 || u > .91 {
+		t.Fatalf("expected %f but got %f", .9, u)
+	}
+}
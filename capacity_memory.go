@@ -0,0 +1,259 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MemorySource selects the measure of memory used by CapacityMemory.
+type MemorySource int
+
+const (
+	// MemorySourceTotal is all memory mapped by the Go runtime, less the heap
+	// memory that has been released to the operating system, as reported by
+	// runtime/metrics. This is the same measure that is constrained by
+	// debug.SetMemoryLimit.
+	MemorySourceTotal MemorySource = iota
+	// MemorySourceHeap is the memory occupied by heap objects as reported by
+	// runtime/metrics. This includes objects that are no longer reachable but
+	// have not yet been collected.
+	MemorySourceHeap
+	// MemorySourceCgroup is the memory charged to the cgroup v2 container as
+	// reported by memory.current. This includes memory outside of the Go
+	// runtime such as the page cache.
+	MemorySourceCgroup
+)
+
+type OptionMemory func(*CapacityMemory)
+
+func OptionMemoryName(name string) OptionMemory {
+	return func(cm *CapacityMemory) {
+		cm.name = name
+	}
+}
+
+// OptionMemorySource sets the measure of memory used. The default is
+// MemorySourceTotal.
+func OptionMemorySource(source MemorySource) OptionMemory {
+	return func(cm *CapacityMemory) {
+		cm.source = source
+	}
+}
+
+// OptionMemoryLimit sets an explicit limit in bytes. This takes precedence over
+// any limit that would otherwise be detected.
+func OptionMemoryLimit(bytes uint64) OptionMemory {
+	return func(cm *CapacityMemory) {
+		cm.limit = bytes
+	}
+}
+
+// OptionMemoryInterval sets how often memory usage is sampled. The default is
+// 1s and the interval must be greater than zero.
+func OptionMemoryInterval(d time.Duration) OptionMemory {
+	return func(cm *CapacityMemory) {
+		cm.interval = d
+	}
+}
+
+// OptionMemoryCgroupDir sets the directory of the cgroup v2 files. The default
+// is the directory of the cgroup of the process, as listed in
+// /proc/self/cgroup, beneath /sys/fs/cgroup or /sys/fs/cgroup itself if that
+// directory is not found.
+func OptionMemoryCgroupDir(dir string) OptionMemory {
+	return func(cm *CapacityMemory) {
+		cm.cgroupDir = dir
+	}
+}
+
+// CapacityMemory reports memory usage as a percentage of a memory limit. The
+// limit is, in order of precedence, the value given to OptionMemoryLimit, the
+// limit set by debug.SetMemoryLimit or the GOMEMLIMIT environment variable, or
+// the cgroup v2 memory.max value. The Go limit applies only to the memory of
+// the Go runtime so MemorySourceCgroup, which also counts memory such as the
+// page cache, always uses memory.max unless an explicit limit is given. The
+// limit is detected on each sample so that changes are observed.
+//
+// Usage is sampled by a background goroutine so that calls to Usage never
+// read from the filesystem or the runtime metrics. Call Close to stop the
+// background goroutine.
+type CapacityMemory struct {
+	name        string
+	source      MemorySource
+	limit       uint64
+	interval    time.Duration
+	cgroupDir   string
+	usage       *atomicFloat32
+	lock        *sync.Mutex
+	samples     []metrics.Sample
+	values      []uint64
+	sampler     *sampler
+	goLimit     func() int64
+	readSamples func(samples []metrics.Sample, values []uint64) error
+}
+
+// NewCapacityMemory begins sampling memory usage. An error is returned if the
+// sampling interval is not positive, if no limit can be determined, or if the
+// selected source cannot be read.
+func NewCapacityMemory(options ...OptionMemory) (*CapacityMemory, error) {
+	c := &CapacityMemory{
+		name:        defaultNameMemory,
+		source:      MemorySourceTotal,
+		interval:    defaultSampleInterval,
+		usage:       &atomicFloat32{},
+		lock:        &sync.Mutex{},
+		goLimit:     readGoMemoryLimit,
+		readSamples: readMetrics,
+	}
+	for _, opt := range options {
+		opt(c)
+	}
+	if c.interval <= 0 {
+		return nil, fmt.Errorf("invalid sampling interval %s", c.interval)
+	}
+	if c.cgroupDir == "" {
+		c.cgroupDir = cgroupDir(defaultCgroupDir, defaultProcDir)
+	}
+	switch c.source {
+	case MemorySourceTotal:
+		c.samples = []metrics.Sample{{Name: metricMemoryTotal}, {Name: metricMemoryReleased}}
+	case MemorySourceHeap:
+		c.samples = []metrics.Sample{{Name: metricMemoryHeap}}
+	case MemorySourceCgroup:
+	default:
+		return nil, fmt.Errorf("unknown memory source %d", c.source)
+	}
+	c.values = make([]uint64, len(c.samples))
+	if _, err := c.read(); err != nil {
+		return nil, err
+	}
+	c.sampler = startSampler(c.interval, c.sample)
+	return c, nil
+}
+
+func (self *CapacityMemory) Name(context.Context) string {
+	return self.name
+}
+
+// Usage returns the most recently sampled memory usage.
+func (self *CapacityMemory) Usage(context.Context) float32 {
+	return self.usage.Load()
+}
+
+// Close stops the background sampling.
+func (self *CapacityMemory) Close() error {
+	self.sampler.Stop()
+	return nil
+}
+
+// sample updates the usage. Errors are ignored so that the last known usage
+// is retained.
+func (self *CapacityMemory) sample() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	usage, err := self.read()
+	if err != nil {
+		return
+	}
+	self.usage.Store(usage)
+}
+
+func (self *CapacityMemory) read() (float32, error) {
+	limit, err := self.readLimit()
+	if err != nil {
+		return 0, err
+	}
+	used, err := self.readUsed()
+	if err != nil {
+		return 0, err
+	}
+	return float32(float64(used) / float64(limit)), nil
+}
+
+func (self *CapacityMemory) readUsed() (uint64, error) {
+	if self.source == MemorySourceCgroup {
+		b, err := os.ReadFile(filepath.Join(self.cgroupDir, "memory.current"))
+		if err != nil {
+			return 0, err
+		}
+		v, err := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid memory.current: %w", err)
+		}
+		return v, nil
+	}
+	if err := self.readSamples(self.samples, self.values); err != nil {
+		return 0, err
+	}
+	if self.source == MemorySourceHeap {
+		return self.values[0], nil
+	}
+	// The released memory is still mapped but does not count towards the
+	// limit of the Go runtime.
+	if self.values[1] > self.values[0] {
+		return 0, nil
+	}
+	return self.values[0] - self.values[1], nil
+}
+
+func (self *CapacityMemory) readLimit() (uint64, error) {
+	if self.limit > 0 {
+		return self.limit, nil
+	}
+	if self.source != MemorySourceCgroup {
+		if limit := self.goLimit(); limit > 0 && limit < math.MaxInt64 {
+			return uint64(limit), nil
+		}
+	}
+	b, err := os.ReadFile(filepath.Join(self.cgroupDir, "memory.max"))
+	if err != nil {
+		return 0, fmt.Errorf("unable to determine a memory limit: %w", err)
+	}
+	s := strings.TrimSpace(string(b))
+	if s == "max" {
+		return 0, errors.New("unable to determine a memory limit: memory.max is unlimited")
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || v == 0 {
+		return 0, fmt.Errorf("invalid memory.max %q", s)
+	}
+	return v, nil
+}
+
+// readMetrics reads the runtime metrics of the samples into the values.
+func readMetrics(samples []metrics.Sample, values []uint64) error {
+	metrics.Read(samples)
+	for offset, sample := range samples {
+		if sample.Value.Kind() != metrics.KindUint64 {
+			return fmt.Errorf("unsupported metric %s", sample.Name)
+		}
+		values[offset] = sample.Value.Uint64()
+	}
+	return nil
+}
+
+// readGoMemoryLimit returns the current limit of the Go runtime without
+// changing it.
+func readGoMemoryLimit() int64 {
+	return debug.SetMemoryLimit(-1)
+}
+
+const defaultNameMemory string = "MEMORY"
+const metricMemoryTotal string = "/memory/classes/total:bytes"
+const metricMemoryHeap string = "/memory/classes/heap/objects:bytes"
+const metricMemoryReleased string = "/memory/classes/heap/released:bytes"
+
+var _ Capacity = &CapacityMemory{}
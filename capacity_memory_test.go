@@ -0,0 +1,156 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"math"
+	"path/filepath"
+	"runtime/metrics"
+	"testing"
+	"time"
+)
+
+func TestCapacityMemoryCgroup(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "memory.max"), "1000\n")
+	writeTestFile(t, filepath.Join(dir, "memory.current"), "250\n")
+	c, err := NewCapacityMemory(
+		OptionMemorySource(MemorySourceCgroup),
+		OptionMemoryCgroupDir(dir),
+		OptionMemoryInterval(time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	defer c.Close()
+
+	ctx := context.Background()
+	if u := c.Usage(ctx); u != .25 {
+		t.Fatalf("expected usage %f but got %f", .25, u)
+	}
+	writeTestFile(t, filepath.Join(dir, "memory.current"), "750\n")
+	c.sample()
+	if u := c.Usage(ctx); u != .75 {
+		t.Fatalf("expected usage %f but got %f", .75, u)
+	}
+}
+
+func TestCapacityMemoryRuntime(t *testing.T) {
+	t.Parallel()
+
+	for _, source := range []MemorySource{MemorySourceTotal, MemorySourceHeap} {
+		c, err := NewCapacityMemory(
+			OptionMemorySource(source),
+			OptionMemoryLimit(1<<40),
+			OptionMemoryInterval(time.Hour),
+		)
+		if err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+		u := c.Usage(context.Background())
+		c.Close()
+		if u <= 0 || u >= 1 {
+			t.Fatalf("expected usage between 0 and 1 for source %d but got %f", source, u)
+		}
+	}
+}
+
+func TestCapacityMemoryTotalReleased(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewCapacityMemory(
+		OptionMemorySource(MemorySourceTotal),
+		OptionMemoryLimit(1000),
+		OptionMemoryInterval(time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	defer c.Close()
+	var total, released uint64 = 800, 300
+	c.readSamples = func(samples []metrics.Sample, values []uint64) error {
+		if len(samples) != 2 || samples[0].Name != metricMemoryTotal || samples[1].Name != metricMemoryReleased {
+			t.Fatalf("unexpected samples %v", samples)
+		}
+		values[0] = total
+		values[1] = released
+		return nil
+	}
+
+	ctx := context.Background()
+	c.sample()
+	if u := c.Usage(ctx); u != .5 {
+		t.Fatalf("expected usage %f but got %f", .5, u)
+	}
+	released = 900
+	c.sample()
+	if u := c.Usage(ctx); u != 0 {
+		t.Fatalf("expected usage %f but got %f", 0.0, u)
+	}
+}
+
+func TestCapacityMemoryInvalidInterval(t *testing.T) {
+	t.Parallel()
+
+	for _, interval := range []time.Duration{0, -time.Second} {
+		_, err := NewCapacityMemory(OptionMemoryLimit(1<<40), OptionMemoryInterval(interval))
+		if err == nil {
+			t.Fatalf("expected an error for interval %s but got nil", interval)
+		}
+	}
+}
+
+func TestCapacityMemoryUnlimited(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "memory.max"), "max\n")
+	writeTestFile(t, filepath.Join(dir, "memory.current"), "250\n")
+	_, err := NewCapacityMemory(OptionMemorySource(MemorySourceCgroup), OptionMemoryCgroupDir(dir))
+	if err == nil {
+		t.Fatal("expected an error but got nil")
+	}
+}
+
+func TestCapacityMemoryLimitSource(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "memory.max"), "1000\n")
+	writeTestFile(t, filepath.Join(dir, "memory.current"), "250\n")
+	c, err := NewCapacityMemory(
+		OptionMemorySource(MemorySourceCgroup),
+		OptionMemoryCgroupDir(dir),
+		OptionMemoryInterval(time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	defer c.Close()
+
+	tests := []struct {
+		source   MemorySource
+		goLimit  int64
+		expected uint64
+	}{
+		{source: MemorySourceCgroup, goLimit: 500, expected: 1000},
+		{source: MemorySourceTotal, goLimit: 500, expected: 500},
+		{source: MemorySourceHeap, goLimit: 500, expected: 500},
+		{source: MemorySourceTotal, goLimit: math.MaxInt64, expected: 1000},
+	}
+	for _, test := range tests {
+		c.source = test.source
+		c.goLimit = func() int64 { return test.goLimit }
+		limit, err := c.readLimit()
+		if err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+		if limit != test.expected {
+			t.Fatalf("expected limit %d for source %d but got %d", test.expected, test.source, limit)
+		}
+	}
+}
@@ -0,0 +1,448 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+type OptionQueue func(*CapacityQueue)
+
+func OptionQueueName(name string) OptionQueue {
+	return func(cq *CapacityQueue) {
+		cq.name = name
+	}
+}
+
+// OptionQueueTimeout sets the maximum amount of time an invocation may wait in
+// the queue before it is rejected. The default value is zero which means that
+// invocations wait until either a slot is available or the context is done.
+func OptionQueueTimeout(d time.Duration) OptionQueue {
+	return func(cq *CapacityQueue) {
+		cq.timeout = d
+	}
+}
+
+// OptionQueueWaitLimit sets the wait time that represents 100% usage of the
+// CapacityQueueWait returned by the WaitTime method. The default value is the
+// queue timeout, if set, or one second.
+func OptionQueueWaitLimit(d time.Duration) OptionQueue {
+	return func(cq *CapacityQueue) {
+		cq.waitLimit = d
+	}
+}
+
+// OptionQueueWaitOptions sets the options used to construct the
+// CapacityQueueWait returned by the WaitTime method. All latency options other
+// than OptionLatencyMeasurePanics are supported.
+func OptionQueueWaitOptions(options ...OptionLatency) OptionQueue {
+	return func(cq *CapacityQueue) {
+		cq.waitOptions = append(cq.waitOptions, options...)
+	}
+}
+
+// CapacityQueue adds a bounded admission queue on top of a
+// CapacityConcurrency. Invocations beyond the concurrency limit wait in the
+// queue, in the order they arrive unless a priority order is configured, until
+// a slot is available, the context is done, or the queue timeout has passed.
+// Invocations that arrive while the queue is full are rejected immediately.
+//
+// The usage value is the current queue length as a percentage of the queue
+// size. The time spent waiting is tracked separately by the capacity returned
+// from the WaitTime method so that both the queue length and the queue wait
+// time can be used to shed load.
+//
+// Each invocation occupies a number of concurrency slots equal to its cost, as
+// given by CostFromContext, and waits until enough slots are available. An
+// invocation whose cost exceeds the concurrency limit is admitted only when no
+// other invocation is active. The queue size limits the number of waiting
+// invocations regardless of their cost.
+//
+// Queueing is applied by the Wrap method. When used with a Shedder then the
+// queue must be installed as part of a RejectionRate, such as with
+// NewFailureProbabilityCurveLinear, so that the wrapper is applied. The
+// CapacityConcurrency is updated by the queue and must not also be used to
+// wrap the same functions.
+type CapacityQueue struct {
+	name        string
+	concurrency *CapacityConcurrency
+	size        int
+	timeout     time.Duration
+	waitLimit   time.Duration
+	waitOptions []OptionLatency
+	wait        *CapacityQueueWait
+	service     *CapacityLatency
+	lock        *sync.Mutex
+	active      int32
+	waiting     *waitQueue
+	priorities  map[Classification]int
+	// observe, if set, is given the wait time of every admitted invocation.
+	observe func(time.Duration)
+	// lifo, if set, is consulted each time a slot is released to determine
+	// whether the most recent arrival should be served first.
+	lifo func() bool
+}
+
+func NewCapacityQueue(concurrency *CapacityConcurrency, size int, options ...OptionQueue) *CapacityQueue {
+	c := &CapacityQueue{
+		name:        defaultNameQueue,
+		concurrency: concurrency,
+		size:        size,
+		lock:        &sync.Mutex{},
+		waiting:     newWaitQueue(1),
+	}
+	for _, opt := range options {
+		opt(c)
+	}
+	if c.waitLimit == 0 {
+		c.waitLimit = c.timeout
+	}
+	if c.waitLimit == 0 {
+		c.waitLimit = time.Second
+	}
+	c.wait = NewCapacityQueueWait(c.waitLimit, c.waitOptions...)
+	c.service = NewCapacityLatency(c.waitLimit)
+	return c
+}
+
+// NewCapacityQueueByClassification creates a queue that orders waiting
+// invocations by their classification. The classes are given in order of
+// priority with the highest priority first and any classification not in the
+// list is given the lowest priority. The classification of each invocation is
+// read using ClassificationFromContext.
+//
+// Waiting invocations with a higher priority are always served before those
+// with a lower priority and invocations of the same priority are served in the
+// order they arrive. When the queue is full, an arriving invocation evicts the
+// most recent arrival of the lowest priority that is below its own. The
+// evicted invocation is rejected with RuleQueueEvicted. If there is no lower
+// priority invocation to evict then the arriving invocation is rejected with
+// RuleQueueFull.
+func NewCapacityQueueByClassification(concurrency *CapacityConcurrency, size int, classes []Classification, options ...OptionQueue) *CapacityQueue {
+	c := NewCapacityQueue(concurrency, size, options...)
+	c.priorities = make(map[Classification]int, len(classes))
+	for offset, class := range classes {
+		if _, ok := c.priorities[class]; !ok {
+			c.priorities[class] = offset
+		}
+	}
+	c.waiting = newWaitQueue(len(classes) + 1)
+	return c
+}
+
+func (self *CapacityQueue) Name(context.Context) string {
+	return self.name
+}
+
+// Usage returns the current queue length as a percentage of the queue size.
+func (self *CapacityQueue) Usage(context.Context) float32 {
+	if self.size < 1 {
+		return 0
+	}
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return float32(float64(self.waiting.Len()) / float64(self.size))
+}
+
+// WaitTime returns the capacity that tracks the amount of time invocations
+// spend waiting in the queue.
+func (self *CapacityQueue) WaitTime() *CapacityQueueWait {
+	return self.wait
+}
+
+// RetryAfter estimates the time needed to drain the current queue based on the
+// queue length, the concurrency limit, and the recent average execution time
+// of admitted functions. The queue timeout is used if there is no recent
+// execution time available.
+func (self *CapacityQueue) RetryAfter(ctx context.Context) time.Duration {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.retryAfter(ctx)
+}
+
+func (self *CapacityQueue) retryAfter(ctx context.Context) time.Duration {
+	service := self.service.Latency(ctx)
+	if service <= 0 || self.concurrency.limit < 1 {
+		return self.timeout
+	}
+	rounds := (self.waiting.Len() / int(self.concurrency.limit)) + 1
+	return time.Duration(rounds) * service
+}
+
+// Wrap a function in admission queueing. The function is not executed if the
+// queue is full or if the wait ends due to a timeout or context cancellation.
+// Queue rejections are reported as an ErrRejection and context cancellations
+// are reported as the context error. Queues of a shadow Shedder do not queue or
+// reject and only track the concurrency. See OptionShedderShadow.
+func (self *CapacityQueue) Wrap(fn Fn) Fn {
+	fn = self.service.Wrap(fn)
+	return func(ctx context.Context) error {
+		if passiveFromContext(ctx) {
+			// The queue belongs to a shadow Shedder and must not affect the
+			// invocation so only the concurrency is recorded.
+			cost := int32(CostFromContext(ctx))
+			self.concurrency.Add(cost)
+			defer self.concurrency.Done(cost)
+			return fn(ctx)
+		}
+		cost := int32(CostFromContext(ctx))
+		if err := self.acquire(ctx, cost); err != nil {
+			return err
+		}
+		defer self.release(cost)
+		return fn(ctx)
+	}
+}
+
+func (self *CapacityQueue) acquire(ctx context.Context, cost int32) error {
+	self.lock.Lock()
+	if self.fits(cost) && self.waiting.Len() < 1 {
+		self.active = self.active + cost
+		self.concurrency.Add(cost)
+		self.lock.Unlock()
+		self.wait.Append(ctx, 0)
+		if self.observe != nil {
+			self.observe(0)
+		}
+		return nil
+	}
+	w := &queueWaiter{
+		ready:          make(chan struct{}),
+		cost:           cost,
+		classification: ClassificationFromContext(ctx),
+		priority:       self.priority(ctx),
+	}
+	if self.waiting.Len() >= self.size {
+		evicted := self.waiting.Evict(w.priority)
+		if evicted == nil {
+			retry := self.retryAfter(ctx)
+			self.lock.Unlock()
+			return ErrRejection{
+				Rule:           RuleQueueFull,
+				Classification: w.classification,
+				RetryAfter:     retry,
+			}
+		}
+		evicted.err = ErrRejection{
+			Rule:           RuleQueueEvicted,
+			Classification: evicted.classification,
+			RetryAfter:     self.retryAfter(ctx),
+		}
+		close(evicted.ready)
+	}
+	start := time.Now()
+	self.waiting.Push(w)
+	self.lock.Unlock()
+
+	var timeout <-chan time.Time
+	if self.timeout > 0 {
+		timer := time.NewTimer(self.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var err error
+	select {
+	case <-w.ready:
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-timeout:
+		err = ErrRejection{
+			Rule:           RuleQueueTimeout,
+			Classification: w.classification,
+			RetryAfter:     self.RetryAfter(ctx),
+		}
+	}
+	waited := time.Since(start)
+	self.wait.Append(ctx, waited)
+
+	self.lock.Lock()
+	switch {
+	case w.err != nil:
+		self.lock.Unlock()
+		return w.err
+	case w.admitted && err == nil:
+		self.lock.Unlock()
+		if self.observe != nil {
+			self.observe(waited)
+		}
+		return nil
+	case w.admitted:
+		// Slots were handed to this invocation at the same time the wait
+		// ended. The slots must be passed along to the next in line.
+		self.lock.Unlock()
+		self.release(cost)
+		return err
+	default:
+		self.waiting.Remove(w)
+		self.lock.Unlock()
+		return err
+	}
+}
+
+// release returns the slots of a completed invocation to the pool and then
+// admits waiting invocations, in order, for as long as the next in line fits
+// within the available slots.
+func (self *CapacityQueue) release(cost int32) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.active = self.active - cost
+	self.concurrency.Done(cost)
+	lifo := self.lifo != nil && self.waiting.Len() > 0 && self.lifo()
+	for {
+		w := self.waiting.Peek(lifo)
+		if w == nil || !self.fits(w.cost) {
+			return
+		}
+		self.waiting.Remove(w)
+		self.active = self.active + w.cost
+		self.concurrency.Add(w.cost)
+		w.admitted = true
+		close(w.ready)
+	}
+}
+
+// fits reports whether an invocation of the given cost may be admitted. An
+// invocation that costs more than the limit fits only when the queue is idle.
+func (self *CapacityQueue) fits(cost int32) bool {
+	return self.active+cost <= self.concurrency.limit || self.active < 1
+}
+
+// priority returns the queue level of the invocation where zero is the
+// highest priority.
+func (self *CapacityQueue) priority(ctx context.Context) int {
+	if self.priorities == nil {
+		return 0
+	}
+	if p, ok := self.priorities[ClassificationFromContext(ctx)]; ok {
+		return p
+	}
+	return len(self.waiting.levels) - 1
+}
+
+type queueWaiter struct {
+	ready          chan struct{}
+	admitted       bool
+	err            error
+	cost           int32
+	classification Classification
+	priority       int
+	element        *list.Element
+}
+
+// waitQueue is a set of FIFO queues, one for each priority level, where level
+// zero is the highest priority.
+type waitQueue struct {
+	levels []*list.List
+	length int
+}
+
+func newWaitQueue(levels int) *waitQueue {
+	q := &waitQueue{
+		levels: make([]*list.List, levels),
+	}
+	for offset := range q.levels {
+		q.levels[offset] = list.New()
+	}
+	return q
+}
+
+func (self *waitQueue) Len() int {
+	return self.length
+}
+
+func (self *waitQueue) Push(w *queueWaiter) {
+	w.element = self.levels[w.priority].PushBack(w)
+	self.length = self.length + 1
+}
+
+// Peek returns the next waiter from the highest priority level that is not
+// empty. The oldest waiter in the level is selected unless lifo is set.
+func (self *waitQueue) Peek(lifo bool) *queueWaiter {
+	for _, level := range self.levels {
+		next := level.Front()
+		if lifo {
+			next = level.Back()
+		}
+		if next != nil {
+			return next.Value.(*queueWaiter)
+		}
+	}
+	return nil
+}
+
+func (self *waitQueue) Remove(w *queueWaiter) {
+	self.levels[w.priority].Remove(w.element)
+	self.length = self.length - 1
+}
+
+// Evict removes the most recent waiter from the lowest priority level that is
+// not empty and is a lower priority than the given level.
+func (self *waitQueue) Evict(priority int) *queueWaiter {
+	for offset := len(self.levels) - 1; offset > priority; offset = offset - 1 {
+		if last := self.levels[offset].Back(); last != nil {
+			w := last.Value.(*queueWaiter)
+			self.Remove(w)
+			return w
+		}
+	}
+	return nil
+}
+
+// CapacityQueueWait tracks the amount of time invocations spend waiting in a
+// CapacityQueue within a window of time. The usage value is the reduction of
+// the window, which is an average by default, as a percentage of the limit.
+//
+// Unlike CapacityLatency, this capacity does not implement Wrapper and is
+// updated only by the queue that created it.
+type CapacityQueueWait struct {
+	latency *CapacityLatency
+}
+
+func NewCapacityQueueWait(limit time.Duration, options ...OptionLatency) *CapacityQueueWait {
+	options = append([]OptionLatency{OptionLatencyName(defaultNameQueueWait)}, options...)
+	return &CapacityQueueWait{
+		latency: NewCapacityLatency(limit, options...),
+	}
+}
+
+func (self *CapacityQueueWait) Name(ctx context.Context) string {
+	return self.latency.Name(ctx)
+}
+
+// Append adds a wait time measure to the underlying window.
+func (self *CapacityQueueWait) Append(ctx context.Context, v time.Duration) {
+	self.latency.Append(ctx, v)
+}
+
+func (self *CapacityQueueWait) Usage(ctx context.Context) float32 {
+	return self.latency.Usage(ctx)
+}
+
+// RetryAfter returns the length of the rolling window.
+func (self *CapacityQueueWait) RetryAfter(ctx context.Context) time.Duration {
+	return self.latency.RetryAfter(ctx)
+}
+
+// RuleQueueFull is the name of the rejection rule used when an invocation
+// arrives at a full CapacityQueue.
+const RuleQueueFull string = "QUEUE FULL"
+
+// RuleQueueEvicted is the name of the rejection rule used when a waiting
+// invocation is removed from a full CapacityQueue to make room for an
+// invocation with a higher priority.
+const RuleQueueEvicted string = "QUEUE EVICTED"
+
+// RuleQueueTimeout is the name of the rejection rule used when an invocation
+// waits in a CapacityQueue for longer than the queue timeout.
+const RuleQueueTimeout string = "QUEUE TIMEOUT"
+
+const defaultNameQueue string = "QUEUE LENGTH"
+const defaultNameQueueWait string = "QUEUE WAIT"
+
+var _ Capacity = &CapacityQueue{}
+var _ RetryAdvisor = &CapacityQueue{}
+var _ Capacity = &CapacityQueueWait{}
@@ -0,0 +1,113 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// CapacityQueueCoDel is a CapacityQueue managed by the controlled delay (CoDel)
+// algorithm. The time each invocation spends in the queue, or sojourn time, is
+// tracked and the queue enters an overloaded state once the minimum sojourn
+// time within an interval exceeds a target. This is detected by waiting for
+// the sojourn time to remain above the target for one full interval.
+//
+// While not overloaded, the queue serves invocations in the order they arrive.
+// While overloaded, the queue serves the most recent arrivals first so that
+// invocations most likely to still be useful are executed and, when used as a
+// Rule, new arrivals are rejected according to the CoDel control law. The
+// first rejection happens when the overload is detected and each following
+// rejection happens after interval/sqrt(count) has passed, where count is the
+// number of rejections made since the overload began. The queue leaves the
+// overloaded state as soon as an invocation is admitted with a sojourn time
+// below the target.
+//
+// This type may be installed in a Shedder either as a Rule, in which case the
+// Shedder also applies the queue wrapper, or as the Capacity of a
+// RejectionRate.
+type CapacityQueueCoDel struct {
+	*CapacityQueue
+	target     time.Duration
+	interval   time.Duration
+	lock       *sync.Mutex
+	firstAbove time.Time
+	dropping   bool
+	dropNext   time.Time
+	count      int
+	now        func() time.Time
+}
+
+// NewCapacityQueueCoDel creates a queue with the given CoDel target and
+// interval. Common values are a target of 5ms and an interval of 100ms. All
+// CapacityQueue options are supported. The default name is CODEL.
+func NewCapacityQueueCoDel(concurrency *CapacityConcurrency, size int, target time.Duration, interval time.Duration, options ...OptionQueue) *CapacityQueueCoDel {
+	options = append([]OptionQueue{OptionQueueName(defaultNameCoDel)}, options...)
+	c := &CapacityQueueCoDel{
+		CapacityQueue: NewCapacityQueue(concurrency, size, options...),
+		target:        target,
+		interval:      interval,
+		lock:          &sync.Mutex{},
+		now:           time.Now,
+	}
+	c.CapacityQueue.observe = c.observe
+	c.CapacityQueue.lifo = c.Overloaded
+	return c
+}
+
+// Overloaded reports whether the queue is currently in the overloaded state.
+func (self *CapacityQueueCoDel) Overloaded() bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	return self.dropping
+}
+
+// Reject returns true if the queue is overloaded and the CoDel control law
+// indicates that the next invocation should be dropped.
+func (self *CapacityQueueCoDel) Reject(ctx context.Context) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	if !self.dropping {
+		return false
+	}
+	now := self.now()
+	if now.Before(self.dropNext) {
+		return false
+	}
+	self.count = self.count + 1
+	self.dropNext = now.Add(self.controlLaw())
+	return true
+}
+
+func (self *CapacityQueueCoDel) observe(sojourn time.Duration) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	now := self.now()
+	if sojourn < self.target {
+		self.firstAbove = time.Time{}
+		self.dropping = false
+		self.count = 0
+		return
+	}
+	if self.firstAbove.IsZero() {
+		self.firstAbove = now.Add(self.interval)
+		return
+	}
+	if !self.dropping && !now.Before(self.firstAbove) {
+		self.dropping = true
+		self.dropNext = now
+	}
+}
+
+func (self *CapacityQueueCoDel) controlLaw() time.Duration {
+	return time.Duration(float64(self.interval) / math.Sqrt(float64(self.count)))
+}
+
+const defaultNameCoDel string = "CODEL"
+
+var _ Capacity = &CapacityQueueCoDel{}
+var _ Rule = &CapacityQueueCoDel{}
+var _ Wrapper = &CapacityQueueCoDel{}
@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCapacityQueueCoDelControlLaw(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Now()
+	q := NewCapacityQueueCoDel(NewCapacityConcurrency(1), 10, 5*time.Millisecond, 100*time.Millisecond)
+	q.now = func() time.Time { return now }
+
+	q.observe(10 * time.Millisecond)
+	if q.Overloaded() {
+		t.Fatal("queue was overloaded before a full interval passed")
+	}
+	if q.Reject(ctx) {
+		t.Fatal("queue rejected before it was overloaded")
+	}
+
+	now = now.Add(50 * time.Millisecond)
+	q.observe(10 * time.Millisecond)
+	if q.Overloaded() {
+		t.Fatal("queue was overloaded before a full interval passed")
+	}
+
+	now = now.Add(50 * time.Millisecond)
+	q.observe(10 * time.Millisecond)
+	if !q.Overloaded() {
+		t.Fatal("queue was not overloaded after a full interval above target")
+	}
+	if !q.Reject(ctx) {
+		t.Fatal("queue did not reject when first overloaded")
+	}
+	if q.Reject(ctx) {
+		t.Fatal("queue rejected before the next drop time")
+	}
+
+	now = now.Add(100 * time.Millisecond)
+	if !q.Reject(ctx) {
+		t.Fatal("queue did not reject at the next drop time")
+	}
+	now = now.Add(60 * time.Millisecond)
+	if q.Reject(ctx) {
+		t.Fatal("queue rejected before the shortened drop time")
+	}
+	now = now.Add(11 * time.Millisecond)
+	if !q.Reject(ctx) {
+		t.Fatal("queue did not reject at the shortened drop time")
+	}
+
+	q.observe(time.Millisecond)
+	if q.Overloaded() {
+		t.Fatal("queue remained overloaded after a sojourn below target")
+	}
+	if q.Reject(ctx) {
+		t.Fatal("queue rejected after leaving the overloaded state")
+	}
+}
+
+func TestCapacityQueueCoDelLIFO(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	q := NewCapacityQueueCoDel(NewCapacityConcurrency(1), 10, time.Nanosecond, time.Nanosecond)
+
+	running := make(chan struct{})
+	block := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- q.Wrap(func(ctx context.Context) error {
+			close(running)
+			<-block
+			return nil
+		})(ctx)
+	}()
+	<-running
+	q.observe(time.Second)
+	q.observe(time.Second)
+	if !q.Overloaded() {
+		t.Fatal("queue was not overloaded")
+	}
+
+	order := make(chan int, 2)
+	queued := make(chan error, 2)
+	for x := 0; x < 2; x = x + 1 {
+		x := x
+		go func() {
+			queued <- q.Wrap(func(ctx context.Context) error {
+				order <- x
+				return nil
+			})(ctx)
+		}()
+		for q.Usage(ctx) != float32(x+1)/10 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	close(block)
+	for x := 0; x < 3; x = x + 1 {
+		var err error
+		select {
+		case err = <-done:
+		case err = <-queued:
+		}
+		if err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+	}
+	if first := <-order; first != 1 {
+		t.Fatalf("expected the most recent arrival to be served first but got %d", first)
+	}
+}
+
+func TestShedderAppliesRuleWrapper(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conc := NewCapacityConcurrency(1)
+	q := NewCapacityQueueCoDel(conc, 1, 5*time.Millisecond, 100*time.Millisecond)
+	shed := NewShedder(
+		OptionShedderRule(q),
+	)
+	var usage float32
+	err := shed.Do(ctx, func(ctx context.Context) error {
+		usage = conc.Usage(ctx)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if usage != 1.0 {
+		t.Fatalf("expected concurrency usage %f but got %f", 1.0, usage)
+	}
+}
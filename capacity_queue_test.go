@@ -0,0 +1,309 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCapacityQueueAdmitsUnderLimit(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conc := NewCapacityConcurrency(1)
+	q := NewCapacityQueue(conc, 1)
+	var usage float32
+	fn := q.Wrap(func(ctx context.Context) error {
+		usage = conc.Usage(ctx)
+		return nil
+	})
+
+	if err := fn(ctx); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if usage != 1.0 {
+		t.Fatalf("expected concurrency usage %f but got %f", 1.0, usage)
+	}
+	if conc.Usage(ctx) != 0.0 {
+		t.Fatalf("expected concurrency usage %f but got %f", 0.0, conc.Usage(ctx))
+	}
+}
+
+func TestCapacityQueueWaitsForSlot(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conc := NewCapacityConcurrency(1)
+	q := NewCapacityQueue(conc, 1)
+	running := make(chan struct{})
+	block := make(chan struct{})
+	blocking := q.Wrap(func(ctx context.Context) error {
+		close(running)
+		<-block
+		return nil
+	})
+	done := make(chan error)
+	go func() {
+		done <- blocking(ctx)
+	}()
+	<-running
+
+	queued := make(chan error)
+	go func() {
+		queued <- q.Wrap(func(ctx context.Context) error { return nil })(ctx)
+	}()
+	for q.Usage(ctx) != 1.0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	err := q.Wrap(func(ctx context.Context) error { return nil })(ctx)
+	var e ErrRejection
+	if !errors.As(err, &e) {
+		t.Fatalf("expected ErrRejection but got %v", err)
+	}
+	if e.Rule != RuleQueueFull {
+		t.Fatalf("expected rule %s but got %s", RuleQueueFull, e.Rule)
+	}
+
+	close(block)
+	if err := <-done; err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if err := <-queued; err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if q.Usage(ctx) != 0.0 {
+		t.Fatalf("expected queue usage %f but got %f", 0.0, q.Usage(ctx))
+	}
+	if conc.Usage(ctx) != 0.0 {
+		t.Fatalf("expected concurrency usage %f but got %f", 0.0, conc.Usage(ctx))
+	}
+	if q.WaitTime().Usage(ctx) <= 0.0 {
+		t.Fatalf("expected a non-zero wait time usage but got %f", q.WaitTime().Usage(ctx))
+	}
+}
+
+func TestCapacityQueueCost(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conc := NewCapacityConcurrency(10)
+	q := NewCapacityQueue(conc, 1)
+	running := make(chan struct{})
+	block := make(chan struct{})
+	blocking := q.Wrap(func(ctx context.Context) error {
+		close(running)
+		<-block
+		return nil
+	})
+	done := make(chan error)
+	go func() {
+		done <- blocking(CostToContext(ctx, 6))
+	}()
+	<-running
+	if conc.Usage(ctx) != .6 {
+		t.Fatalf("expected concurrency usage %f but got %f", .6, conc.Usage(ctx))
+	}
+
+	var usage float32
+	queued := make(chan error)
+	go func() {
+		queued <- q.Wrap(func(ctx context.Context) error {
+			usage = conc.Usage(ctx)
+			return nil
+		})(CostToContext(ctx, 6))
+	}()
+	for q.Usage(ctx) != 1.0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(block)
+	if err := <-done; err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if err := <-queued; err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if usage != .6 {
+		t.Fatalf("expected concurrency usage %f but got %f", .6, usage)
+	}
+	if conc.Usage(ctx) != 0.0 {
+		t.Fatalf("expected concurrency usage %f but got %f", 0.0, conc.Usage(ctx))
+	}
+}
+
+func TestCapacityQueueTimeout(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conc := NewCapacityConcurrency(1)
+	q := NewCapacityQueue(conc, 1, OptionQueueTimeout(time.Millisecond))
+	running := make(chan struct{})
+	block := make(chan struct{})
+	blocking := q.Wrap(func(ctx context.Context) error {
+		close(running)
+		<-block
+		return nil
+	})
+	done := make(chan error)
+	go func() {
+		done <- blocking(ctx)
+	}()
+	<-running
+
+	called := false
+	err := q.Wrap(func(ctx context.Context) error {
+		called = true
+		return nil
+	})(ctx)
+	var e ErrRejection
+	if !errors.As(err, &e) {
+		t.Fatalf("expected ErrRejection but got %v", err)
+	}
+	if e.Rule != RuleQueueTimeout {
+		t.Fatalf("expected rule %s but got %s", RuleQueueTimeout, e.Rule)
+	}
+	if called {
+		t.Fatal("timed out function was executed")
+	}
+	if q.Usage(ctx) != 0.0 {
+		t.Fatalf("expected queue usage %f but got %f", 0.0, q.Usage(ctx))
+	}
+
+	close(block)
+	if err := <-done; err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if conc.Usage(ctx) != 0.0 {
+		t.Fatalf("expected concurrency usage %f but got %f", 0.0, conc.Usage(ctx))
+	}
+}
+
+func TestCapacityQueueContextDone(t *testing.T) {
+	t.Parallel()
+
+	conc := NewCapacityConcurrency(1)
+	q := NewCapacityQueue(conc, 1)
+	running := make(chan struct{})
+	block := make(chan struct{})
+	blocking := q.Wrap(func(ctx context.Context) error {
+		close(running)
+		<-block
+		return nil
+	})
+	done := make(chan error)
+	go func() {
+		done <- blocking(context.Background())
+	}()
+	<-running
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	err := q.Wrap(func(ctx context.Context) error { return nil })(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %s but got %v", context.DeadlineExceeded, err)
+	}
+
+	close(block)
+	if err := <-done; err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+}
+
+func TestCapacityQueueByClassification(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	high := Classification("HIGH")
+	normal := Classification("NORMAL")
+	low := Classification("LOW")
+	q := NewCapacityQueueByClassification(NewCapacityConcurrency(1), 2, []Classification{high, normal, low})
+	running := make(chan struct{})
+	block := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- q.Wrap(func(ctx context.Context) error {
+			close(running)
+			<-block
+			return nil
+		})(ctx)
+	}()
+	<-running
+
+	order := make(chan Classification, 3)
+	fn := q.Wrap(func(ctx context.Context) error {
+		order <- ClassificationFromContext(ctx)
+		return nil
+	})
+	lowResult := make(chan error, 1)
+	go func() {
+		lowResult <- fn(ClassificationToContext(ctx, low))
+	}()
+	for q.Usage(ctx) != .5 {
+		time.Sleep(time.Millisecond)
+	}
+	normalResult := make(chan error, 1)
+	go func() {
+		normalResult <- fn(ClassificationToContext(ctx, normal))
+	}()
+	for q.Usage(ctx) != 1.0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// The queue is full so a LOW arrival is rejected and a HIGH arrival
+	// evicts the waiting LOW invocation.
+	err := fn(ClassificationToContext(ctx, low))
+	var e ErrRejection
+	if !errors.As(err, &e) || e.Rule != RuleQueueFull {
+		t.Fatalf("expected %s rejection but got %v", RuleQueueFull, err)
+	}
+	highResult := make(chan error, 1)
+	go func() {
+		highResult <- fn(ClassificationToContext(ctx, high))
+	}()
+	err = <-lowResult
+	if !errors.As(err, &e) || e.Rule != RuleQueueEvicted {
+		t.Fatalf("expected %s rejection but got %v", RuleQueueEvicted, err)
+	}
+	if e.Classification != low {
+		t.Fatalf("expected classification %s but got %s", low, e.Classification)
+	}
+	for q.Usage(ctx) != 1.0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	close(block)
+	for _, result := range []chan error{done, highResult, normalResult} {
+		if err := <-result; err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+	}
+	if first := <-order; first != high {
+		t.Fatalf("expected %s to be served first but got %s", high, first)
+	}
+	if second := <-order; second != normal {
+		t.Fatalf("expected %s to be served second but got %s", normal, second)
+	}
+}
+
+func TestCapacityQueueRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	q := NewCapacityQueue(NewCapacityConcurrency(2), 10, OptionQueueTimeout(time.Second))
+	if d := q.RetryAfter(ctx); d != time.Second {
+		t.Fatalf("expected the queue timeout %s but got %s", time.Second, d)
+	}
+	fn := q.Wrap(func(ctx context.Context) error {
+		time.Sleep(time.Millisecond)
+		return nil
+	})
+	_ = fn(ctx)
+	if d := q.RetryAfter(ctx); d < time.Millisecond || d >= time.Second {
+		t.Fatalf("expected a drain estimate near %s but got %s", time.Millisecond, d)
+	}
+}
@@ -0,0 +1,222 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+type OptionRuntime func(*CapacityRuntime)
+
+func OptionRuntimeName(name string) OptionRuntime {
+	return func(cr *CapacityRuntime) {
+		cr.name = name
+	}
+}
+
+// OptionRuntimeInterval sets how often the runtime metrics are sampled. The
+// default is 1s and the interval must be greater than zero.
+func OptionRuntimeInterval(d time.Duration) OptionRuntime {
+	return func(cr *CapacityRuntime) {
+		cr.interval = d
+	}
+}
+
+// OptionRuntimePercentile sets the percentile, given in the form 90.0 or 99.9,
+// used by capacities based on a distribution such as scheduler latency. The
+// default is 99 and the percentile must be greater than zero and at most 100.
+func OptionRuntimePercentile(perc float64) OptionRuntime {
+	return func(cr *CapacityRuntime) {
+		cr.percentile = perc
+	}
+}
+
+// CapacityRuntime reports the health of the Go runtime based on a metric from
+// runtime/metrics. Use NewCapacityGoroutines, NewCapacitySchedulerLatency, or
+// NewCapacityGCCPU to create one.
+//
+// Metrics are sampled by a background goroutine so that calls to Usage only
+// load the most recent value. Call Close to stop the background goroutine.
+type CapacityRuntime struct {
+	name       string
+	interval   time.Duration
+	percentile float64
+	usage      *atomicFloat32
+	lock       *sync.Mutex
+	samples    []metrics.Sample
+	measure    func([]metrics.Sample) (float32, bool)
+	sampler    *sampler
+	last       []uint64
+}
+
+func newCapacityRuntime(name string, options []OptionRuntime) (*CapacityRuntime, error) {
+	c := &CapacityRuntime{
+		name:       name,
+		interval:   defaultSampleInterval,
+		percentile: defaultRuntimePercentile,
+		usage:      &atomicFloat32{},
+		lock:       &sync.Mutex{},
+	}
+	for _, opt := range options {
+		opt(c)
+	}
+	if c.interval <= 0 {
+		return nil, fmt.Errorf("invalid sampling interval %s", c.interval)
+	}
+	if c.percentile <= 0 || c.percentile > 100 || math.IsNaN(c.percentile) {
+		return nil, fmt.Errorf("invalid percentile %f", c.percentile)
+	}
+	return c, nil
+}
+
+func (self *CapacityRuntime) start(measure func([]metrics.Sample) (float32, bool), names ...string) (*CapacityRuntime, error) {
+	self.measure = measure
+	for _, name := range names {
+		self.samples = append(self.samples, metrics.Sample{Name: name})
+	}
+	self.sampler = startSampler(self.interval, self.sample)
+	return self, nil
+}
+
+// NewCapacityGoroutines reports the number of live goroutines as a percentage
+// of the limit. An error is returned if the sampling interval is not positive.
+func NewCapacityGoroutines(limit int, options ...OptionRuntime) (*CapacityRuntime, error) {
+	c, err := newCapacityRuntime(defaultNameGoroutines, options)
+	if err != nil {
+		return nil, err
+	}
+	return c.start(func(samples []metrics.Sample) (float32, bool) {
+		if samples[0].Value.Kind() != metrics.KindUint64 {
+			return 0, false
+		}
+		return float32(float64(samples[0].Value.Uint64()) / float64(limit)), true
+	}, metricGoroutines)
+}
+
+// NewCapacitySchedulerLatency reports a percentile of the time goroutines
+// spent waiting to run as a percentage of the target. Only the goroutines
+// scheduled since the previous sample are considered. An error is returned if
+// the sampling interval is not positive or the percentile is not valid.
+func NewCapacitySchedulerLatency(target time.Duration, options ...OptionRuntime) (*CapacityRuntime, error) {
+	c, err := newCapacityRuntime(defaultNameSchedulerLatency, options)
+	if err != nil {
+		return nil, err
+	}
+	return c.start(func(samples []metrics.Sample) (float32, bool) {
+		if samples[0].Value.Kind() != metrics.KindFloat64Histogram {
+			return 0, false
+		}
+		return c.histogramUsage(samples[0].Value.Float64Histogram(), target), true
+	}, metricSchedulerLatency)
+}
+
+// histogramUsage returns the percentile of the values added to the cumulative
+// histogram since the previous call as a percentage of the target.
+func (self *CapacityRuntime) histogramUsage(h *metrics.Float64Histogram, target time.Duration) float32 {
+	counts := make([]uint64, len(h.Counts))
+	copy(counts, h.Counts)
+	delta := counts
+	if len(self.last) == len(counts) {
+		delta = make([]uint64, len(counts))
+		for offset := range counts {
+			delta[offset] = counts[offset] - self.last[offset]
+		}
+	}
+	self.last = counts
+	value := histogramPercentile(delta, h.Buckets, self.percentile/100)
+	return float32(value / target.Seconds())
+}
+
+// NewCapacityGCCPU reports the fraction of CPU time spent on garbage
+// collection since the previous sample as a percentage of the limit. For
+// example, a limit of .25 reports full usage when a quarter of all CPU time
+// is spent on garbage collection. An error is returned if the sampling
+// interval is not positive.
+func NewCapacityGCCPU(limit float32, options ...OptionRuntime) (*CapacityRuntime, error) {
+	c, err := newCapacityRuntime(defaultNameGCCPU, options)
+	if err != nil {
+		return nil, err
+	}
+	var lastGC, lastTotal float64
+	return c.start(func(samples []metrics.Sample) (float32, bool) {
+		if samples[0].Value.Kind() != metrics.KindFloat64 || samples[1].Value.Kind() != metrics.KindFloat64 {
+			return 0, false
+		}
+		gc, total := samples[0].Value.Float64(), samples[1].Value.Float64()
+		deltaGC, deltaTotal := gc-lastGC, total-lastTotal
+		lastGC, lastTotal = gc, total
+		if deltaTotal <= 0 {
+			return 0, false
+		}
+		return float32(deltaGC/deltaTotal) / limit, true
+	}, metricGCCPU, metricTotalCPU)
+}
+
+func (self *CapacityRuntime) Name(context.Context) string {
+	return self.name
+}
+
+// Usage returns the most recently sampled value.
+func (self *CapacityRuntime) Usage(context.Context) float32 {
+	return self.usage.Load()
+}
+
+// Close stops the background sampling.
+func (self *CapacityRuntime) Close() error {
+	self.sampler.Stop()
+	return nil
+}
+
+func (self *CapacityRuntime) sample() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	metrics.Read(self.samples)
+	if usage, ok := self.measure(self.samples); ok {
+		self.usage.Store(usage)
+	}
+}
+
+// histogramPercentile returns the upper bound of the bucket that contains the
+// quantile, between 0 and 1. The lower bound is used for the final bucket if
+// it is unbounded. Zero is returned for an empty histogram.
+func histogramPercentile(counts []uint64, buckets []float64, p float64) float64 {
+	var total uint64
+	for _, c := range counts {
+		total = total + c
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(p * float64(total)))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen uint64
+	for offset, c := range counts {
+		seen = seen + c
+		if seen >= rank {
+			if upper := buckets[offset+1]; !math.IsInf(upper, 1) {
+				return upper
+			}
+			return buckets[offset]
+		}
+	}
+	return buckets[len(buckets)-1]
+}
+
+const defaultNameGoroutines string = "GOROUTINES"
+const defaultNameSchedulerLatency string = "SCHEDULER LATENCY"
+const defaultNameGCCPU string = "GC CPU"
+const defaultRuntimePercentile float64 = 99
+const metricGoroutines string = "/sched/goroutines:goroutines"
+const metricSchedulerLatency string = "/sched/latencies:seconds"
+const metricGCCPU string = "/cpu/classes/gc/total:cpu-seconds"
+const metricTotalCPU string = "/cpu/classes/total:cpu-seconds"
+
+var _ Capacity = &CapacityRuntime{}
@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"runtime/metrics"
+	"testing"
+	"time"
+)
+
+func TestCapacityGoroutines(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewCapacityGoroutines(1000000, OptionRuntimeInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	done := make(chan struct{})
+	defer close(done)
+	for x := 0; x < 1000; x = x + 1 {
+		go func() { <-done }()
+	}
+	c.sample()
+	if u := c.Usage(context.Background()); u < .001 {
+		t.Fatalf("expected usage of at least %f but got %f", .001, u)
+	}
+}
+
+func TestCapacityRuntimeInvalidInterval(t *testing.T) {
+	t.Parallel()
+
+	for _, interval := range []time.Duration{0, -time.Second} {
+		_, err := NewCapacityGoroutines(10, OptionRuntimeInterval(interval))
+		if err == nil {
+			t.Fatalf("expected an error for interval %s but got nil", interval)
+		}
+	}
+}
+
+func TestCapacitySchedulerLatency(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewCapacitySchedulerLatency(time.Second, OptionRuntimeInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	runtime.Gosched()
+	c.sample()
+	if u := c.Usage(context.Background()); u < 0 || math.IsNaN(float64(u)) {
+		t.Fatalf("expected a valid usage but got %f", u)
+	}
+}
+
+func TestCapacitySchedulerLatencyPercentile(t *testing.T) {
+	t.Parallel()
+
+	target := 10 * time.Millisecond
+	// The percentile uses the same scale as OptionLatencyPercentile.
+	c, err := NewCapacitySchedulerLatency(target, OptionRuntimeInterval(time.Hour), OptionRuntimePercentile(90))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	buckets := []float64{0, .001, .005, .01, math.Inf(1)}
+
+	c.histogramUsage(&metrics.Float64Histogram{Counts: []uint64{100, 0, 0, 50}, Buckets: buckets}, target)
+	// Only the values added since the previous sample count so the 90th
+	// percentile of 9 fast values and 1 slow value is the fast bucket.
+	u := c.histogramUsage(&metrics.Float64Histogram{Counts: []uint64{100, 9, 1, 50}, Buckets: buckets}, target)
+	if u < .499 || u > .501 {
+		t.Fatalf("expected usage %f but got %f", .5, u)
+	}
+	u = c.histogramUsage(&metrics.Float64Histogram{Counts: []uint64{100, 9, 2, 60}, Buckets: buckets}, target)
+	if u < .999 || u > 1.001 {
+		t.Fatalf("expected usage %f but got %f", 1.0, u)
+	}
+}
+
+func TestCapacityRuntimeInvalidPercentile(t *testing.T) {
+	t.Parallel()
+
+	for _, percentile := range []float64{0, -1, 100.1, math.NaN()} {
+		_, err := NewCapacitySchedulerLatency(time.Second, OptionRuntimePercentile(percentile))
+		if err == nil {
+			t.Fatalf("expected an error for percentile %f but got nil", percentile)
+		}
+	}
+}
+
+func TestCapacityGCCPU(t *testing.T) {
+	t.Parallel()
+
+	c, err := NewCapacityGCCPU(1, OptionRuntimeInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	runtime.GC()
+	c.sample()
+	if u := c.Usage(context.Background()); u < 0 || u > 1 {
+		t.Fatalf("expected usage between 0 and 1 but got %f", u)
+	}
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	t.Parallel()
+
+	buckets := []float64{0, 1, 2, 3, math.Inf(1)}
+	tests := []struct {
+		name     string
+		counts   []uint64
+		p        float64
+		expected float64
+	}{
+		{name: "empty", counts: []uint64{0, 0, 0, 0}, p: .99, expected: 0},
+		{name: "median", counts: []uint64{5, 3, 1, 1}, p: .5, expected: 1},
+		{name: "p90", counts: []uint64{5, 3, 1, 1}, p: .9, expected: 3},
+		{name: "unbounded", counts: []uint64{5, 3, 1, 1}, p: .99, expected: 3},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			if v := histogramPercentile(test.counts, buckets, test.p); v != test.expected {
+				t.Fatalf("expected %f but got %f", test.expected, v)
+			}
+		})
+	}
+}
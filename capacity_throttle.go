@@ -53,4 +53,13 @@ func (self *CapacityThrottle) Wrap(fn Fn) Fn {
 	return fn
 }
 
+// RetryAfter returns the larger of the throttle duration and any advice from
+// the wrapped Capacity.
+func (self *CapacityThrottle) RetryAfter(ctx context.Context) time.Duration {
+	if d := retryAfter(ctx, self.Capacity); d > self.duration {
+		return d
+	}
+	return self.duration
+}
+
 var _ Capacity = &CapacityThrottle{}
@@ -0,0 +1,547 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kevinconway/rolling/v3"
+
+	"github.com/kevinconway/loadshed/v2"
+)
+
+// Option provides the Go components referenced by a Document.
+type Option func(*builder)
+
+// OptionRule registers a Rule that a Document may reference by name.
+func OptionRule(name string, r loadshed.Rule) Option {
+	return func(b *builder) {
+		b.rules[name] = r
+	}
+}
+
+// OptionClassifier registers a Classifier that a Document may reference by
+// name.
+func OptionClassifier(name string, c loadshed.Classifier) Option {
+	return func(b *builder) {
+		b.classifiers[name] = c
+	}
+}
+
+// OptionCapacity registers an existing Capacity that a Document may reference
+// by name in place of a capacity defined in the document. This may be used to
+// share a Capacity between documents, such as when calling Update on a
+// Shedder, so that its state is retained.
+func OptionCapacity(name string, c loadshed.Capacity) Option {
+	return func(b *builder) {
+		b.capacities[name] = c
+	}
+}
+
+// OptionShedder adds Shedder options that are applied after those derived from
+// the Document. This may be used to install components that have no document
+// representation, such as an Observer.
+func OptionShedder(options ...loadshed.OptionShedder) Option {
+	return func(b *builder) {
+		b.extra = append(b.extra, options...)
+	}
+}
+
+// FieldError describes an invalid value within a Document. The path uses a
+// dotted notation, such as rejectionRates[0].capacity, to identify the value.
+type FieldError struct {
+	Path    string
+	Message string
+}
+
+func (self *FieldError) Error() string {
+	return fmt.Sprintf("%s: %s", self.Path, self.Message)
+}
+
+// Build validates the Document and creates a Shedder from it. If the Document
+// is invalid then the returned error contains a FieldError for every problem
+// found.
+func Build(doc *Document, options ...Option) (*loadshed.Shedder, error) {
+	opts, err := ShedderOptions(doc, options...)
+	if err != nil {
+		return nil, err
+	}
+	return loadshed.NewShedder(opts...), nil
+}
+
+// ShedderOptions validates the Document and converts it to a set of Shedder
+// options. This is useful for updating an existing Shedder with the Update
+// method.
+func ShedderOptions(doc *Document, options ...Option) ([]loadshed.OptionShedder, error) {
+	b := &builder{
+		rules:       map[string]loadshed.Rule{},
+		classifiers: map[string]loadshed.Classifier{},
+		capacities:  map[string]loadshed.Capacity{},
+		wrapped:     map[string]bool{},
+	}
+	for _, opt := range options {
+		opt(b)
+	}
+	return b.build(doc)
+}
+
+type builder struct {
+	rules       map[string]loadshed.Rule
+	classifiers map[string]loadshed.Classifier
+	capacities  map[string]loadshed.Capacity
+	wrapped     map[string]bool
+	extra       []loadshed.OptionShedder
+	errs        []error
+}
+
+func (self *builder) fail(path string, format string, args ...interface{}) {
+	self.errs = append(self.errs, &FieldError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+func (self *builder) build(doc *Document) ([]loadshed.OptionShedder, error) {
+	if doc == nil {
+		return nil, errors.New("missing document")
+	}
+	var result []loadshed.OptionShedder
+
+	capacities := make(map[string]loadshed.Capacity, len(doc.Capacities)+len(self.capacities))
+	for name, c := range self.capacities {
+		capacities[name] = c
+	}
+	names := make([]string, 0, len(doc.Capacities))
+	for name := range doc.Capacities {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		path := "capacities." + name
+		if _, ok := self.capacities[name]; ok {
+			self.fail(path, "conflicts with a registered capacity of the same name")
+			continue
+		}
+		if c := self.capacity(path, doc.Capacities[name]); c != nil {
+			capacities[name] = c
+		}
+	}
+
+	for offset, r := range doc.RejectionRates {
+		path := fmt.Sprintf("rejectionRates[%d]", offset)
+		if rate := self.rejectionRate(path, r, capacities); rate != nil {
+			result = append(result, loadshed.OptionShedderRejectionRate(rate))
+		}
+	}
+
+	for offset, name := range doc.Rules {
+		path := fmt.Sprintf("rules[%d]", offset)
+		r, ok := self.rules[name]
+		if !ok {
+			self.fail(path, "unknown rule %q", name)
+			continue
+		}
+		result = append(result, loadshed.OptionShedderRule(r))
+	}
+
+	if doc.Classifier != "" {
+		c, ok := self.classifiers[doc.Classifier]
+		if !ok {
+			self.fail("classifier", "unknown classifier %q", doc.Classifier)
+		} else {
+			result = append(result, loadshed.OptionShedderClassifier(c))
+		}
+	}
+
+	if doc.Combiner != nil {
+		if c := self.combiner("combiner", *doc.Combiner); c != nil {
+			result = append(result, loadshed.OptionShedderRejectionCombiner(c))
+		}
+	}
+
+	if doc.DryRun {
+		result = append(result, loadshed.OptionShedderDryRun(true))
+	}
+
+	if doc.Probe != nil {
+		if p := self.probe("probe", *doc.Probe); p != nil {
+			result = append(result, loadshed.OptionShedderProbe(p))
+		}
+	}
+
+	if len(self.errs) > 0 {
+		return nil, errors.Join(self.errs...)
+	}
+	return append(result, self.extra...), nil
+}
+
+func (self *builder) capacity(path string, c Capacity) loadshed.Capacity {
+	var result loadshed.Capacity
+	w := Window{}
+	if c.Window != nil {
+		w = *c.Window
+		if w.Buckets < 0 {
+			self.fail(path+".window.buckets", "must not be negative")
+		}
+		if w.BucketDuration < 0 {
+			self.fail(path+".window.bucketDuration", "must not be negative")
+		}
+		if w.MinimumPoints < 0 {
+			self.fail(path+".window.minimumPoints", "must not be negative")
+		}
+	}
+	if c.Type != typeLatency && c.Reduction != "" {
+		self.fail(path+".reduction", "is only valid for the %s type", typeLatency)
+	}
+	switch c.Type {
+	case typeConcurrency:
+		limit, ok := self.intLimit(path, c.Limit, 32)
+		if !ok {
+			return nil
+		}
+		if c.Window != nil {
+			self.fail(path+".window", "is not valid for the %s type", c.Type)
+		}
+		var opts []loadshed.OptionConcurrency
+		if c.Name != "" {
+			opts = append(opts, loadshed.OptionConcurrencyName(c.Name))
+		}
+		result = loadshed.NewCapacityConcurrency(int32(limit), opts...)
+	case typeErrorRate:
+		if len(c.Limit) > 0 {
+			self.fail(path+".limit", "is not valid for the %s type", c.Type)
+		}
+		var opts []loadshed.OptionErrorRate
+		if c.Name != "" {
+			opts = append(opts, loadshed.OptionErrorRateName(c.Name))
+		}
+		if w.Buckets > 0 {
+			opts = append(opts, loadshed.OptionErrorRateWindowBuckets(w.Buckets))
+		}
+		if w.BucketDuration > 0 {
+			opts = append(opts, loadshed.OptionErrorRateBucketDuration(time.Duration(w.BucketDuration)))
+		}
+		if w.BucketSizeHint > 0 {
+			opts = append(opts, loadshed.OptionErrorRateBucketSizeHint(w.BucketSizeHint))
+		}
+		if w.MinimumPoints > 0 {
+			opts = append(opts, loadshed.OptionErrorRateMinimumPoints(w.MinimumPoints))
+		}
+		result = loadshed.NewCapacityErrorRate(opts...)
+	case typeLandingRate:
+		limit, ok := self.intLimit(path, c.Limit, strconv.IntSize)
+		if !ok {
+			return nil
+		}
+		var opts []loadshed.OptionLandingRate
+		if c.Name != "" {
+			opts = append(opts, loadshed.OptionLandingrateName(c.Name))
+		}
+		if w.Buckets > 0 {
+			opts = append(opts, loadshed.OptionLandingRateWindowBuckets(w.Buckets))
+		}
+		if w.BucketDuration > 0 {
+			opts = append(opts, loadshed.OptionLandingRateBucketDuration(time.Duration(w.BucketDuration)))
+		}
+		if w.BucketSizeHint > 0 {
+			opts = append(opts, loadshed.OptionLandingRateBucketSizeHint(w.BucketSizeHint))
+		}
+		if w.MinimumPoints > 0 {
+			self.fail(path+".window.minimumPoints", "is not valid for the %s type", c.Type)
+		}
+		result = loadshed.NewCapacityLandingRate(limit, opts...)
+	case typeLatency:
+		limit, ok := self.durationLimit(path, c.Limit)
+		if !ok {
+			return nil
+		}
+		var opts []loadshed.OptionLatency
+		if c.Name != "" {
+			opts = append(opts, loadshed.OptionLatencyName(c.Name))
+		}
+		if w.Buckets > 0 {
+			opts = append(opts, loadshed.OptionLatencyWindowBuckets(w.Buckets))
+		}
+		if w.BucketDuration > 0 {
+			opts = append(opts, loadshed.OptionLatencyBucketDuration(time.Duration(w.BucketDuration)))
+		}
+		if w.BucketSizeHint > 0 {
+			opts = append(opts, loadshed.OptionLatencyBucketSizeHint(w.BucketSizeHint))
+		}
+		if w.MinimumPoints > 0 {
+			opts = append(opts, loadshed.OptionLatencyMinimumPoints(w.MinimumPoints))
+		}
+		if c.MeasurePanics {
+			opts = append(opts, loadshed.OptionLatencyMeasurePanics(true))
+		}
+		if c.Reduction != "" {
+			opt, ok := self.reduction(path+".reduction", c.Reduction)
+			if !ok {
+				return nil
+			}
+			opts = append(opts, opt)
+		}
+		result = loadshed.NewCapacityLatency(limit, opts...)
+	case "":
+		self.fail(path+".type", "is required")
+		return nil
+	default:
+		self.fail(path+".type", "unknown capacity type %q", c.Type)
+		return nil
+	}
+	if c.Type != typeLatency && c.MeasurePanics {
+		self.fail(path+".measurePanics", "is only valid for the %s type", typeLatency)
+	}
+	if c.Throttle < 0 {
+		self.fail(path+".throttle", "must not be negative")
+	}
+	if c.Throttle > 0 {
+		result = loadshed.NewCapacityThrottle(result, time.Duration(c.Throttle))
+	}
+	return result
+}
+
+// intLimit parses a limit that must fit within a signed integer of the given
+// bit size.
+func (self *builder) intLimit(path string, raw json.RawMessage, bits int) (int, bool) {
+	if len(raw) < 1 {
+		self.fail(path+".limit", "is required")
+		return 0, false
+	}
+	limit, err := strconv.ParseInt(string(raw), 10, bits)
+	if errors.Is(err, strconv.ErrRange) {
+		self.fail(path+".limit", "must be at most %d but got %s", int64(1)<<(bits-1)-1, raw)
+		return 0, false
+	}
+	if err != nil {
+		self.fail(path+".limit", "must be an integer but got %s", raw)
+		return 0, false
+	}
+	if limit < 1 {
+		self.fail(path+".limit", "must be greater than zero")
+		return 0, false
+	}
+	return int(limit), true
+}
+
+func (self *builder) durationLimit(path string, raw json.RawMessage) (time.Duration, bool) {
+	if len(raw) < 1 {
+		self.fail(path+".limit", "is required")
+		return 0, false
+	}
+	var limit Duration
+	if err := json.Unmarshal(raw, &limit); err != nil {
+		self.fail(path+".limit", "must be a duration but got %s", raw)
+		return 0, false
+	}
+	if limit <= 0 {
+		self.fail(path+".limit", "must be greater than zero")
+		return 0, false
+	}
+	return time.Duration(limit), true
+}
+
+// reduction returns the latency option for a named reduction. Percentiles are
+// calculated with a quantile sketch so that memory use does not grow with the
+// request rate.
+func (self *builder) reduction(path string, name string) (loadshed.OptionLatency, bool) {
+	switch name {
+	case "avg":
+		return loadshed.OptionLatencyReduction(rolling.Avg[time.Duration]), true
+	case "min":
+		return loadshed.OptionLatencyReduction(rolling.Min[time.Duration]), true
+	case "max":
+		return loadshed.OptionLatencyReduction(rolling.Max[time.Duration]), true
+	}
+	if strings.HasPrefix(name, "p") {
+		perc, err := strconv.ParseFloat(name[1:], 64)
+		if err == nil && perc > 0 && perc <= 100 {
+			return loadshed.OptionLatencyPercentile(perc), true
+		}
+	}
+	self.fail(path, "unknown reduction %q", name)
+	return nil, false
+}
+
+func (self *builder) rejectionRate(path string, r RejectionRate, capacities map[string]loadshed.Capacity) loadshed.RejectionRate {
+	if r.Capacity == "" {
+		self.fail(path+".capacity", "is required")
+		return nil
+	}
+	capacity, ok := capacities[r.Capacity]
+	if !ok {
+		self.fail(path+".capacity", "unknown capacity %q", r.Capacity)
+		return nil
+	}
+	if _, ok := capacity.(loadshed.Wrapper); ok {
+		if self.wrapped[r.Capacity] {
+			capacity = sharedCapacity{Capacity: capacity}
+		}
+		self.wrapped[r.Capacity] = true
+	}
+	probCurve := self.curve(path+".probability", r.Probability)
+	rateCurve := self.curve(path+".rate", r.Rate)
+	names := make([]string, 0, len(r.Classes))
+	for class := range r.Classes {
+		names = append(names, class)
+	}
+	sort.Strings(names)
+	classes := make(map[loadshed.Classification]loadshed.Curve, len(r.Classes))
+	for _, class := range names {
+		c := r.Classes[class]
+		classes[loadshed.Classification(class)] = self.curve(path+".classes."+class, &c)
+	}
+	if probCurve == nil || rateCurve == nil {
+		return nil
+	}
+	for _, c := range classes {
+		if c == nil {
+			return nil
+		}
+	}
+	prob := &loadshed.FailureProbabilityCurve{
+		Capacity: capacity,
+		Curve:    probCurve,
+	}
+	var rate loadshed.RejectionRate = loadshed.NewRejectionRateCurveByClassification(prob, rateCurve, classes)
+	if r.Slew != nil {
+		if r.Slew.Hysteresis < 0 {
+			self.fail(path+".slew.hysteresis", "must not be negative")
+			return nil
+		}
+		rate = loadshed.NewRejectionRateSlew(rate, r.Slew.Attack, r.Slew.Release, loadshed.OptionSlewHysteresis(r.Slew.Hysteresis))
+	}
+	if r.Cost {
+		rate = loadshed.NewRejectionRateCost(rate)
+	}
+	return rate
+}
+
+func (self *builder) curve(path string, c *Curve) loadshed.Curve {
+	if c == nil {
+		return identity
+	}
+	switch c.Type {
+	case curveIdentity:
+		if c.Lower != 0 || c.Upper != 0 || c.Exponent != nil {
+			self.fail(path, "bounds and exponent are not valid for the %s type", c.Type)
+			return nil
+		}
+		return identity
+	case curveLinear:
+		exponent := float32(1)
+		if c.Exponent != nil {
+			exponent = *c.Exponent
+		}
+		if c.Upper <= c.Lower {
+			self.fail(path+".upper", "must be greater than lower")
+			return nil
+		}
+		if exponent <= 0 {
+			self.fail(path+".exponent", "must be greater than zero")
+			return nil
+		}
+		return &loadshed.CurveLinear{
+			Lower:    c.Lower,
+			Upper:    c.Upper,
+			Exponent: exponent,
+		}
+	case "":
+		self.fail(path+".type", "is required")
+	default:
+		self.fail(path+".type", "unknown curve type %q", c.Type)
+	}
+	return nil
+}
+
+func (self *builder) probe(path string, p Probe) loadshed.Probe {
+	switch {
+	case p.Fraction != 0 && (p.Count != 0 || p.Interval != 0):
+		self.fail(path, "fraction may not be combined with count or interval")
+	case p.Fraction < 0 || p.Fraction > 1:
+		self.fail(path+".fraction", "must be between 0 and 1")
+	case p.Fraction > 0:
+		return loadshed.NewProbeFraction(p.Fraction)
+	case p.Count < 1:
+		self.fail(path+".count", "must be positive")
+	case p.Interval <= 0:
+		self.fail(path+".interval", "must be positive")
+	default:
+		return loadshed.NewProbeInterval(p.Count, time.Duration(p.Interval))
+	}
+	return nil
+}
+
+func (self *builder) combiner(path string, c Combiner) loadshed.RejectionCombiner {
+	if c.Type != combinerWeightedSum && len(c.Weights) > 0 {
+		self.fail(path+".weights", "is only valid for the %s type", combinerWeightedSum)
+	}
+	if c.Type != combinerProbabilisticOr && c.Limit != nil {
+		self.fail(path+".limit", "is only valid for the %s type", combinerProbabilisticOr)
+	}
+	switch c.Type {
+	case combinerSequential:
+		return loadshed.NewRejectionCombinerSequential()
+	case combinerMax:
+		return loadshed.NewRejectionCombinerMax()
+	case combinerWeightedSum:
+		for offset, w := range c.Weights {
+			if w < 0 {
+				self.fail(fmt.Sprintf("%s.weights[%d]", path, offset), "must not be negative")
+			}
+		}
+		return loadshed.NewRejectionCombinerWeightedSum(c.Weights...)
+	case combinerProbabilisticOr:
+		limit := float32(1)
+		if c.Limit != nil {
+			limit = *c.Limit
+		}
+		if limit <= 0 || limit > 1 {
+			self.fail(path+".limit", "must be greater than zero and no more than one")
+		}
+		return loadshed.NewRejectionCombinerProbabilisticOr(limit)
+	case "":
+		self.fail(path+".type", "is required")
+	default:
+		self.fail(path+".type", "unknown combiner type %q", c.Type)
+	}
+	return nil
+}
+
+// sharedCapacity hides the Wrapper of a capacity that is referenced by more
+// than one rejection rate so that the capacity records each invocation only
+// once. The first rejection rate to reference the capacity applies the wrapper.
+type sharedCapacity struct {
+	loadshed.Capacity
+}
+
+func (self sharedCapacity) RetryAfter(ctx context.Context) time.Duration {
+	if a, ok := self.Capacity.(loadshed.RetryAdvisor); ok {
+		return a.RetryAfter(ctx)
+	}
+	return 0
+}
+
+var identity = loadshed.CurveFN(func(ctx context.Context, value float32) float32 { //nolint:gochecknoglobals
+	return value
+})
+
+const (
+	typeConcurrency = "concurrency"
+	typeErrorRate   = "errorRate"
+	typeLandingRate = "landingRate"
+	typeLatency     = "latency"
+
+	curveIdentity = "identity"
+	curveLinear   = "linear"
+
+	combinerSequential      = "sequential"
+	combinerMax             = "max"
+	combinerWeightedSum     = "weightedSum"
+	combinerProbabilisticOr = "probabilisticOr"
+)
@@ -0,0 +1,256 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package config
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kevinconway/loadshed/v2"
+)
+
+func TestBuild(t *testing.T) {
+	t.Parallel()
+
+	doc, err := Parse([]byte(`{
+		"capacities": {
+			"concurrency": {"type": "concurrency", "limit": 1},
+			"errors": {"type": "errorRate", "window": {"buckets": 10, "bucketDuration": "1ms", "minimumPoints": 1}},
+			"landing": {"type": "landingRate", "limit": 1000, "throttle": "10ms"},
+			"latency": {"type": "latency", "limit": "50ms", "reduction": "p99.9"}
+		},
+		"rejectionRates": [
+			{"capacity": "concurrency", "probability": {"type": "linear", "lower": 0.5, "upper": 1, "exponent": 2}},
+			{"capacity": "errors", "slew": {"attack": 1, "release": 0.5, "hysteresis": 0.05}},
+			{"capacity": "landing", "rate": {"type": "identity"}, "cost": true},
+			{
+				"capacity": "latency",
+				"rate": {"type": "linear", "lower": 0, "upper": 1},
+				"classes": {"LOW": {"type": "linear", "lower": 0, "upper": 0.5}}
+			}
+		],
+		"rules": ["static"],
+		"classifier": "static",
+		"combiner": {"type": "probabilisticOr", "limit": 0.9},
+		"probe": {"count": 1, "interval": "1s"}
+	}`))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	cls := loadshed.Classification("LOW")
+	shed, err := Build(doc,
+		OptionRule("static", &staticRule{}),
+		OptionClassifier("static", loadshed.ClassifierFN(func(ctx context.Context) loadshed.Classification { return cls })),
+	)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	found := loadshed.Classification("")
+	err = shed.Do(context.Background(), func(ctx context.Context) error {
+		found = loadshed.ClassificationFromContext(ctx)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if found != cls {
+		t.Fatalf("expected classification %s but got %s", cls, found)
+	}
+}
+
+func TestBuildInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		doc  string
+		path string
+	}{
+		{
+			name: "missing capacity type",
+			doc:  `{"capacities": {"c": {"limit": 1}}}`,
+			path: "capacities.c.type",
+		},
+		{
+			name: "unknown capacity type",
+			doc:  `{"capacities": {"c": {"type": "cpu"}}}`,
+			path: "capacities.c.type",
+		},
+		{
+			name: "missing limit",
+			doc:  `{"capacities": {"c": {"type": "concurrency"}}}`,
+			path: "capacities.c.limit",
+		},
+		{
+			name: "concurrency limit out of range",
+			doc:  `{"capacities": {"c": {"type": "concurrency", "limit": 4294967297}}}`,
+			path: "capacities.c.limit",
+		},
+		{
+			name: "non-positive concurrency limit",
+			doc:  `{"capacities": {"c": {"type": "concurrency", "limit": 0}}}`,
+			path: "capacities.c.limit",
+		},
+		{
+			name: "invalid latency limit",
+			doc:  `{"capacities": {"c": {"type": "latency", "limit": "fast"}}}`,
+			path: "capacities.c.limit",
+		},
+		{
+			name: "unknown reduction",
+			doc:  `{"capacities": {"c": {"type": "latency", "limit": "1s", "reduction": "median"}}}`,
+			path: "capacities.c.reduction",
+		},
+		{
+			name: "unknown rate capacity",
+			doc:  `{"rejectionRates": [{"capacity": "missing"}]}`,
+			path: "rejectionRates[0].capacity",
+		},
+		{
+			name: "invalid curve bounds",
+			doc:  `{"capacities": {"c": {"type": "errorRate"}}, "rejectionRates": [{"capacity": "c", "probability": {"type": "linear", "lower": 1, "upper": 0}}]}`,
+			path: "rejectionRates[0].probability.upper",
+		},
+		{
+			name: "invalid class curve",
+			doc:  `{"capacities": {"c": {"type": "errorRate"}}, "rejectionRates": [{"capacity": "c", "classes": {"LOW": {"type": "square"}}}]}`,
+			path: "rejectionRates[0].classes.LOW.type",
+		},
+		{
+			name: "unknown rule",
+			doc:  `{"rules": ["missing"]}`,
+			path: "rules[0]",
+		},
+		{
+			name: "unknown classifier",
+			doc:  `{"classifier": "missing"}`,
+			path: "classifier",
+		},
+		{
+			name: "invalid slew",
+			doc:  `{"capacities": {"c": {"type": "errorRate"}}, "rejectionRates": [{"capacity": "c", "slew": {"hysteresis": -1}}]}`,
+			path: "rejectionRates[0].slew.hysteresis",
+		},
+		{
+			name: "invalid probe",
+			doc:  `{"probe": {"count": 1}}`,
+			path: "probe.interval",
+		},
+		{
+			name: "invalid combiner",
+			doc:  `{"combiner": {"type": "max", "weights": [1]}}`,
+			path: "combiner.weights",
+		},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			doc, err := Parse([]byte(tt.doc))
+			if err != nil {
+				t.Fatalf("got unexpected error: %s", err)
+			}
+			_, err = Build(doc)
+			var fe *FieldError
+			if !errors.As(err, &fe) {
+				t.Fatalf("expected a FieldError but got %v", err)
+			}
+			if fe.Path != tt.path {
+				t.Fatalf("expected path %s but got %s", tt.path, fe.Path)
+			}
+		})
+	}
+}
+
+func TestBuildReportsAllErrors(t *testing.T) {
+	t.Parallel()
+
+	doc, err := Parse([]byte(`{"rules": ["a", "b"], "classifier": "c"}`))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	_, err = Build(doc)
+	if err == nil {
+		t.Fatal("expected an error but got nil")
+	}
+	for _, path := range []string{"rules[0]", "rules[1]", "classifier"} {
+		if !strings.Contains(err.Error(), path+":") {
+			t.Fatalf("expected an error for %s but got %s", path, err)
+		}
+	}
+}
+
+func TestParseUnknownField(t *testing.T) {
+	t.Parallel()
+
+	_, err := Parse([]byte(`{"capacity": {}}`))
+	if err == nil {
+		t.Fatal("expected an error but got nil")
+	}
+}
+
+func TestBuildRegisteredCapacity(t *testing.T) {
+	t.Parallel()
+
+	conc := loadshed.NewCapacityConcurrency(1)
+	doc, err := Parse([]byte(`{"rejectionRates": [{"capacity": "shared"}]}`))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	opts, err := ShedderOptions(doc, OptionCapacity("shared", conc))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	shed := loadshed.NewShedder(opts...)
+	var usage float32
+	err = shed.Do(context.Background(), func(ctx context.Context) error {
+		usage = conc.Usage(ctx)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if usage != 1 {
+		t.Fatalf("expected usage %f but got %f", 1.0, usage)
+	}
+}
+
+func TestBuildSharedCapacity(t *testing.T) {
+	t.Parallel()
+
+	conc := loadshed.NewCapacityConcurrency(10)
+	doc, err := Parse([]byte(`{"rejectionRates": [{"capacity": "shared"}, {"capacity": "shared"}]}`))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	opts, err := ShedderOptions(doc, OptionCapacity("shared", conc))
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	shed := loadshed.NewShedder(opts...)
+	var usage float32
+	err = shed.Do(context.Background(), func(ctx context.Context) error {
+		usage = conc.Usage(ctx)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if usage != .1 {
+		t.Fatalf("expected usage %f but got %f", .1, usage)
+	}
+}
+
+type staticRule struct{}
+
+func (*staticRule) Name(context.Context) string {
+	return "static"
+}
+
+func (*staticRule) Reject(context.Context) bool {
+	return false
+}
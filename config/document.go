@@ -0,0 +1,200 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+// Package config builds a loadshed.Shedder from a declarative document.
+//
+// Documents are parsed from JSON. YAML documents are supported by converting
+// them to JSON first, for example with sigs.k8s.io/yaml, because the document
+// types use only JSON struct tags. A minimal document looks like:
+//
+//	{
+//	  "capacities": {
+//	    "latency": {"type": "latency", "limit": "50ms", "reduction": "p90"}
+//	  },
+//	  "rejectionRates": [
+//	    {
+//	      "capacity": "latency",
+//	      "probability": {"type": "linear", "lower": 0.8, "upper": 1.2},
+//	      "rate": {"type": "identity"},
+//	      "classes": {"LOW": {"type": "linear", "lower": 0, "upper": 0.5}}
+//	    }
+//	  ]
+//	}
+//
+// Rules and classifiers are implemented in Go and are referenced by name from
+// a document. Each name must be registered with OptionRule or
+// OptionClassifier when building the Shedder.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Document is the root of a declarative load shedding policy.
+type Document struct {
+	// Capacities are the named capacities that may be referenced by rejection
+	// rates.
+	Capacities map[string]Capacity `json:"capacities,omitempty"`
+	// RejectionRates are the probabilistic policies in the order they are
+	// given to the Shedder.
+	RejectionRates []RejectionRate `json:"rejectionRates,omitempty"`
+	// Rules are the names of registered rules in the order they are given to
+	// the Shedder.
+	Rules []string `json:"rules,omitempty"`
+	// Classifier is the name of a registered classifier.
+	Classifier string `json:"classifier,omitempty"`
+	// Combiner optionally sets the strategy for combining rejection rates.
+	Combiner *Combiner `json:"combiner,omitempty"`
+	// DryRun enables dry-run mode for the Shedder.
+	DryRun bool `json:"dryRun,omitempty"`
+	// Probe optionally admits a portion of invocations that would otherwise
+	// be rejected by the rejection rates.
+	Probe *Probe `json:"probe,omitempty"`
+}
+
+// Capacity describes one of the capacity types included in the loadshed
+// package.
+type Capacity struct {
+	// Type is one of concurrency, errorRate, landingRate, or latency.
+	Type string `json:"type"`
+	// Name optionally overrides the default capacity name.
+	Name string `json:"name,omitempty"`
+	// Limit is an integer for concurrency and landingRate capacities and a
+	// duration for latency capacities. It is not used for errorRate.
+	Limit json.RawMessage `json:"limit,omitempty"`
+	// Window configures the rolling window of the errorRate, landingRate, and
+	// latency capacities.
+	Window *Window `json:"window,omitempty"`
+	// Reduction is the latency reduction. Valid values are avg, min, max, and
+	// percentiles in the form p50 or p99.9. Percentiles are calculated with a
+	// quantile sketch. The default is avg.
+	Reduction string `json:"reduction,omitempty"`
+	// MeasurePanics enables latency measurement of panics.
+	MeasurePanics bool `json:"measurePanics,omitempty"`
+	// Throttle optionally limits how often the usage is calculated.
+	Throttle Duration `json:"throttle,omitempty"`
+}
+
+// Window configures a rolling window. Zero values are replaced by the
+// defaults of the capacity.
+type Window struct {
+	Buckets        int      `json:"buckets,omitempty"`
+	BucketDuration Duration `json:"bucketDuration,omitempty"`
+	BucketSizeHint int      `json:"bucketSizeHint,omitempty"`
+	MinimumPoints  int      `json:"minimumPoints,omitempty"`
+}
+
+// RejectionRate describes a capacity, the curve used to convert its usage to a
+// failure probability, and the curves used to convert the failure probability
+// to a rejection rate.
+type RejectionRate struct {
+	// Capacity is the name of an entry in the document capacities. A capacity
+	// may be referenced by more than one rejection rate and still records each
+	// invocation only once.
+	Capacity string `json:"capacity"`
+	// Probability is the curve applied to the capacity usage. The default is
+	// the identity curve.
+	Probability *Curve `json:"probability,omitempty"`
+	// Rate is the default curve applied to the failure probability. The
+	// default is the identity curve.
+	Rate *Curve `json:"rate,omitempty"`
+	// Classes are curves applied to the failure probability for specific
+	// classifications.
+	Classes map[string]Curve `json:"classes,omitempty"`
+	// Cost scales the rejection rate by the cost of each invocation so that
+	// expensive invocations are rejected earlier than cheap ones.
+	Cost bool `json:"cost,omitempty"`
+	// Slew optionally limits how quickly the rejection rate changes.
+	Slew *Slew `json:"slew,omitempty"`
+}
+
+// Slew describes the limits on how quickly a rejection rate changes.
+type Slew struct {
+	// Attack is the maximum increase in rate per second.
+	Attack float32 `json:"attack,omitempty"`
+	// Release is the maximum decrease in rate per second.
+	Release float32 `json:"release,omitempty"`
+	// Hysteresis is the band within which rate changes are ignored.
+	Hysteresis float32 `json:"hysteresis,omitempty"`
+}
+
+// Curve describes either a linear or identity curve.
+type Curve struct {
+	// Type is either linear or identity.
+	Type  string  `json:"type"`
+	Lower float32 `json:"lower,omitempty"`
+	Upper float32 `json:"upper,omitempty"`
+	// Exponent of the linear curve. The default is 1.
+	Exponent *float32 `json:"exponent,omitempty"`
+}
+
+// Combiner describes the strategy for combining rejection rates.
+type Combiner struct {
+	// Type is one of sequential, max, weightedSum, or probabilisticOr.
+	Type string `json:"type"`
+	// Weights are used by the weightedSum type.
+	Weights []float32 `json:"weights,omitempty"`
+	// Limit is the maximum rate of the probabilisticOr type. The default is 1.
+	Limit *float32 `json:"limit,omitempty"`
+}
+
+// Probe describes either a fraction of rejected invocations or a fixed count
+// of rejected invocations per interval that are admitted as probes. Exactly
+// one of Fraction or Count must be set.
+type Probe struct {
+	Fraction float32  `json:"fraction,omitempty"`
+	Count    int      `json:"count,omitempty"`
+	Interval Duration `json:"interval,omitempty"`
+}
+
+// Duration is a time.Duration that is encoded as a string, such as "10ms", or
+// as an integer number of nanoseconds.
+type Duration time.Duration
+
+func (self *Duration) UnmarshalJSON(b []byte) error {
+	if len(b) > 0 && b[0] == '"' {
+		var s string
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		*self = Duration(d)
+		return nil
+	}
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid duration %s", b)
+	}
+	*self = Duration(n)
+	return nil
+}
+
+func (self Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(self).String())
+}
+
+// Parse decodes a JSON document. Unknown fields are rejected so that mistakes
+// in a document are not silently ignored. The document is not validated until
+// it is built.
+func Parse(b []byte) (*Document, error) {
+	return Decode(bytes.NewReader(b))
+}
+
+// Decode reads a JSON document from the reader. See Parse for details.
+func Decode(r io.Reader) (*Document, error) {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	doc := &Document{}
+	if err := dec.Decode(doc); err != nil {
+		return nil, fmt.Errorf("invalid document: %w", err)
+	}
+	return doc, nil
+}
@@ -0,0 +1,34 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import "context"
+
+type costCtxKeyType struct{}
+
+var costCtxKey = costCtxKeyType{} //nolint: gochecknoglobals
+
+// CostFromContext returns the relative cost of an invocation. The default cost
+// is 1 when no cost is set.
+func CostFromContext(ctx context.Context) int {
+	v := ctx.Value(costCtxKey)
+	if v == nil {
+		return defaultCost
+	}
+	return v.(int)
+}
+
+// CostToContext sets the relative cost of an invocation. For example, a bulk
+// export might have a cost of 100 while a health check has a cost of 1. The
+// concurrency, landing rate, and error rate capacities account for each
+// invocation by its cost and an admission queue reserves one slot for each
+// unit of cost. Costs less than zero are treated as zero.
+func CostToContext(ctx context.Context, cost int) context.Context {
+	if cost < 0 {
+		cost = 0
+	}
+	return context.WithValue(ctx, costCtxKey, cost)
+}
+
+const defaultCost int = 1
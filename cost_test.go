@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestCostCapacities(t *testing.T) {
+	t.Parallel()
+
+	ctx := CostToContext(context.Background(), 5)
+	conc := NewCapacityConcurrency(10)
+	landing := NewCapacityLandingRate(10)
+	errRate := NewCapacityErrorRate()
+
+	var usage float32
+	_ = conc.Wrap(func(ctx context.Context) error {
+		usage = conc.Usage(ctx)
+		return nil
+	})(ctx)
+	if usage != .5 {
+		t.Fatalf("expected concurrency usage %f but got %f", .5, usage)
+	}
+	if u := conc.Usage(ctx); u != 0 {
+		t.Fatalf("expected concurrency usage %f but got %f", 0.0, u)
+	}
+
+	_ = landing.Wrap(func(ctx context.Context) error { return nil })(ctx)
+	_ = landing.Wrap(func(ctx context.Context) error { return nil })(context.Background())
+	if u := landing.Usage(ctx); u != .6 {
+		t.Fatalf("expected landing rate usage %f but got %f", .6, u)
+	}
+
+	_ = errRate.Wrap(func(ctx context.Context) error { return errors.New("TEST") })(ctx)
+	_ = errRate.Wrap(func(ctx context.Context) error { return nil })(context.Background())
+	if u := errRate.Usage(ctx); u < .833 || u > .834 {
+		t.Fatalf("expected error rate usage %f but got %f", .833, u)
+	}
+}
+
+func TestCostFromContextDefault(t *testing.T) {
+	t.Parallel()
+
+	if c := CostFromContext(context.Background()); c != 1 {
+		t.Fatalf("expected cost %d but got %d", 1, c)
+	}
+	if c := CostFromContext(CostToContext(context.Background(), -1)); c != 0 {
+		t.Fatalf("expected cost %d but got %d", 0, c)
+	}
+}
@@ -4,7 +4,10 @@
 
 package loadshed
 
-import "context"
+import (
+	"context"
+	"time"
+)
 
 // Fn is the basic unit of execution and represents an action that may be shed
 // under load.
@@ -67,12 +70,43 @@ type Rule interface {
 // interface by returning a wrapped copy of the passed in function that tracks
 // the start and end times.
 //
+// A Rule may also implement this interface in order to manage the execution of
+// functions that it admits. For example, a queue management policy may both
+// reject invocations and delay the execution of admitted functions.
+//
 // Implementing this behaviour is optional and this interface is only exposed
 // for documentation purposes.
 type Wrapper interface {
 	Wrap(Fn) Fn
 }
 
+// RetryAdvisor is an optional interface that any Rule, Capacity,
+// FailureProbability, or RejectionRate may implement in order to estimate how
+// long a rejected caller should wait before trying again. For example, a
+// capacity based on a rolling window might advise waiting for the length of
+// the window so that the rejected load is no longer counted.
+//
+// Implementations of FailureProbability and RejectionRate that wrap another
+// type should account for the wrapped type's optional RetryAdvisor interface.
+type RetryAdvisor interface {
+	RetryAfter(ctx context.Context) time.Duration
+}
+
+// Evaluator is an optional interface that any FailureProbability or
+// RejectionRate may implement in order to produce all of its values from a
+// single measurement of the underlying Capacity. The Shedder uses the Decision
+// both to make its decision and to describe any resulting rejection so that
+// the reported values always match those that caused the rejection. Without
+// this interface the Shedder calls Rate and then, only if needed to describe a
+// rejection, calls the Name, Usage, and Likelihood methods.
+//
+// Implementations of FailureProbability and RejectionRate that wrap another
+// type should account for the wrapped type's optional Evaluator interface. A
+// FailureProbability does not set the Rate of the Decision.
+type Evaluator interface {
+	Evaluate(ctx context.Context) Decision
+}
+
 // Curve is a function used to scale or plot a value. The primary use cases for
 // a curving function is to either translate a capacity usage to a failure
 // probability or translate a failure probability to a rejection rate. The
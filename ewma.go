@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// ewma is an exponentially weighted moving sum of a value and a weight that
+// decay together over time. The ratio of the two is an exponentially weighted
+// moving average, such as the error rate when the value is the number of errors
+// and the weight is the number of attempts, and the weight alone is an
+// exponentially weighted count of recent events.
+//
+// Updates and reads take constant time, use constant memory, and never block.
+// Each update replaces the entire state using a compare and swap so that the
+// value and weight are always consistent. An update is retried only when
+// another update replaced the state first.
+type ewma struct {
+	tau   float64
+	state atomic.Pointer[ewmaState]
+	now   func() time.Time
+}
+
+type ewmaState struct {
+	value  float64
+	weight float64
+	at     int64
+}
+
+// newEWMA creates an average where each measurement loses half of its
+// influence after the given half-life. The half-life must be positive. See
+// ewmaHalfLife.
+func newEWMA(halfLife time.Duration) *ewma {
+	e := &ewma{
+		tau: float64(halfLife.Nanoseconds()) / math.Ln2,
+		now: time.Now,
+	}
+	e.state.Store(&ewmaState{})
+	return e
+}
+
+// ewmaHalfLife returns the half-life, or the default half-life if the given
+// value is not positive. Without a positive half-life the measurements would
+// never decay.
+func ewmaHalfLife(halfLife time.Duration) time.Duration {
+	if halfLife <= 0 {
+		return defaultEWMAHalfLife
+	}
+	return halfLife
+}
+
+// decay returns the value and weight of the state as of the given time.
+func (self *ewma) decay(s *ewmaState, at int64) (float64, float64) {
+	if at <= s.at {
+		return s.value, s.weight
+	}
+	factor := math.Exp(-float64(at-s.at) / self.tau)
+	return s.value * factor, s.weight * factor
+}
+
+// Add decays the current state to the present and then adds the value and
+// weight of a new measurement.
+func (self *ewma) Add(value float64, weight float64) {
+	at := self.now().UnixNano()
+	for {
+		current := self.state.Load()
+		v, w := self.decay(current, at)
+		next := &ewmaState{value: v + value, weight: w + weight, at: at}
+		if at < current.at {
+			// Another update recorded a later time after this one read the
+			// clock. The difference is too small to matter so the new
+			// measurement is added without moving the state back in time.
+			next.at = current.at
+		}
+		if self.state.CompareAndSwap(current, next) {
+			return
+		}
+	}
+}
+
+// Load returns the value and weight decayed to the present.
+func (self *ewma) Load() (float64, float64) {
+	return self.decay(self.state.Load(), self.now().UnixNano())
+}
+
+// defaultEWMAHalfLife matches the default window of the rolling window
+// capacities.
+const defaultEWMAHalfLife time.Duration = time.Second
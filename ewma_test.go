@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEWMADecay(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(0, 0)
+	e := newEWMA(time.Second)
+	e.now = func() time.Time { return now }
+
+	e.Add(4, 8)
+	value, weight := e.Load()
+	if value != 4 || weight != 8 {
+		t.Fatalf("expected %f/%f but got %f/%f", 4.0, 8.0, value, weight)
+	}
+	now = now.Add(time.Second)
+	value, weight = e.Load()
+	if value < 1.999 || value > 2.001 || weight < 3.999 || weight > 4.001 {
+		t.Fatalf("expected %f/%f but got %f/%f", 2.0, 4.0, value, weight)
+	}
+	e.Add(0, 4)
+	now = now.Add(time.Second)
+	value, weight = e.Load()
+	if value < .999 || value > 1.001 || weight < 3.999 || weight > 4.001 {
+		t.Fatalf("expected %f/%f but got %f/%f", 1.0, 4.0, value, weight)
+	}
+}
+
+func TestEWMAHalfLifeDefault(t *testing.T) {
+	t.Parallel()
+
+	for _, halfLife := range []time.Duration{0, -time.Second} {
+		now := time.Unix(0, 0)
+		e := newEWMA(ewmaHalfLife(halfLife))
+		e.now = func() time.Time { return now }
+		e.Add(2, 2)
+		now = now.Add(defaultEWMAHalfLife)
+		if value, _ := e.Load(); value < .999 || value > 1.001 {
+			t.Fatalf("expected %f for half-life %s but got %f", 1.0, halfLife, value)
+		}
+	}
+}
+
+func TestEWMAConcurrent(t *testing.T) {
+	t.Parallel()
+
+	e := newEWMA(time.Hour)
+	wg := &sync.WaitGroup{}
+	for x := 0; x < 8; x = x + 1 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for y := 0; y < 1000; y = y + 1 {
+				e.Add(1, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	value, weight := e.Load()
+	if weight < 7990 || weight > 8000 {
+		t.Fatalf("expected %f but got %f", 8000.0, weight)
+	}
+	if value != weight {
+		t.Fatalf("expected %f but got %f", weight, value)
+	}
+}
+
+func BenchmarkEWMAAdd(b *testing.B) {
+	b.ReportAllocs()
+	e := newEWMA(time.Second)
+	for n := 0; n < b.N; n = n + 1 {
+		e.Add(1, 1)
+	}
+}
+
+func BenchmarkEWMAAddParallel(b *testing.B) {
+	b.ReportAllocs()
+	e := newEWMA(time.Second)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			e.Add(1, 1)
+		}
+	})
+}
@@ -0,0 +1,199 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// latencySketch is a quantile sketch in the style of DDSketch. Values are
+// counted in logarithmically sized bins so that any quantile is reported with
+// a bounded relative error while the memory used depends only on the range of
+// values recorded rather than the number of values. The number of bins is
+// also capped and the lowest bins are collapsed together when the cap is
+// reached, which only affects the accuracy of the lowest quantiles.
+type latencySketch struct {
+	gamma    float64
+	logGamma float64
+	offset   int
+	bins     []uint64
+	zero     uint64
+	count    uint64
+}
+
+func newLatencySketch() *latencySketch {
+	gamma := (1 + sketchRelativeAccuracy) / (1 - sketchRelativeAccuracy)
+	return &latencySketch{
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+	}
+}
+
+func (self *latencySketch) index(v time.Duration) int {
+	return int(math.Ceil(math.Log(float64(v)) / self.logGamma))
+}
+
+// value returns the representative value of a bin which is within the
+// relative accuracy of every value counted in the bin.
+func (self *latencySketch) value(index int) time.Duration {
+	return time.Duration(2 * math.Pow(self.gamma, float64(index)) / (self.gamma + 1))
+}
+
+func (self *latencySketch) Add(v time.Duration) {
+	self.addCount(v, 1)
+}
+
+func (self *latencySketch) addCount(v time.Duration, n uint64) {
+	self.count = self.count + n
+	if v <= 0 {
+		self.zero = self.zero + n
+		return
+	}
+	self.addIndex(self.index(v), n)
+}
+
+func (self *latencySketch) addIndex(index int, n uint64) {
+	if len(self.bins) < 1 {
+		self.offset = index
+		self.bins = append(self.bins, 0)
+	}
+	if index < self.offset {
+		if self.offset+len(self.bins)-index > sketchMaxBins {
+			// Collapse values below the lowest bin that fits.
+			self.bins[0] = self.bins[0] + n
+			return
+		}
+		grown := make([]uint64, self.offset-index+len(self.bins))
+		copy(grown[self.offset-index:], self.bins)
+		self.bins = grown
+		self.offset = index
+	}
+	if index >= self.offset+len(self.bins) {
+		for index >= self.offset+len(self.bins) {
+			self.bins = append(self.bins, 0)
+		}
+		if len(self.bins) > sketchMaxBins {
+			drop := len(self.bins) - sketchMaxBins
+			var collapsed uint64
+			for _, c := range self.bins[:drop] {
+				collapsed = collapsed + c
+			}
+			self.bins = self.bins[drop:]
+			self.bins[0] = self.bins[0] + collapsed
+			self.offset = self.offset + drop
+		}
+	}
+	self.bins[index-self.offset] = self.bins[index-self.offset] + n
+}
+
+// Merge adds all values of the other sketch to this one.
+func (self *latencySketch) Merge(other *latencySketch) {
+	self.count = self.count + other.zero
+	self.zero = self.zero + other.zero
+	for offset, c := range other.bins {
+		if c > 0 {
+			self.count = self.count + c
+			self.addIndex(other.offset+offset, c)
+		}
+	}
+}
+
+// Quantile returns the value at the quantile, between 0 and 1, or zero if the
+// sketch is empty.
+func (self *latencySketch) Quantile(q float64) time.Duration {
+	if self.count < 1 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(self.count)))
+	if rank < 1 {
+		rank = 1
+	}
+	seen := self.zero
+	if seen >= rank {
+		return 0
+	}
+	for offset, c := range self.bins {
+		seen = seen + c
+		if seen >= rank {
+			return self.value(self.offset + offset)
+		}
+	}
+	return self.value(self.offset + len(self.bins) - 1)
+}
+
+func (self *latencySketch) Reset() {
+	self.bins = self.bins[:0]
+	self.zero = 0
+	self.count = 0
+}
+
+// latencySketchWindow is a rolling window of time that keeps a latencySketch
+// for each bucket in place of the individual values. Buckets are rolled out of
+// the window in the same way as a rolling.TimePolicy.
+type latencySketchWindow struct {
+	lock           *sync.Mutex
+	buckets        []*latencySketch
+	merged         *latencySketch
+	bucketDuration int64
+	lastTime       int64
+	lastOffset     int
+	now            func() time.Time
+}
+
+func newLatencySketchWindow(buckets int, bucketDuration time.Duration) *latencySketchWindow {
+	w := &latencySketchWindow{
+		lock:           &sync.Mutex{},
+		buckets:        make([]*latencySketch, buckets),
+		merged:         newLatencySketch(),
+		bucketDuration: bucketDuration.Nanoseconds(),
+		now:            time.Now,
+	}
+	for offset := range w.buckets {
+		w.buckets[offset] = newLatencySketch()
+	}
+	return w
+}
+
+// roll empties any buckets that have left the window and returns the bucket
+// for the current time.
+func (self *latencySketchWindow) roll() *latencySketch {
+	current := self.now().UnixNano() / self.bucketDuration
+	offset := int(current % int64(len(self.buckets)))
+	if distance := current - self.lastTime; distance > 0 {
+		if distance > int64(len(self.buckets)) {
+			distance = int64(len(self.buckets))
+		}
+		for x := 1; x <= int(distance); x = x + 1 {
+			self.buckets[(self.lastOffset+x)%len(self.buckets)].Reset()
+		}
+		self.lastTime = current
+		self.lastOffset = offset
+	}
+	return self.buckets[offset]
+}
+
+func (self *latencySketchWindow) Append(ctx context.Context, v time.Duration) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.roll().Add(v)
+}
+
+// Quantile merges the sketches of every bucket and returns the value at the
+// quantile.
+func (self *latencySketchWindow) Quantile(ctx context.Context, q float64) time.Duration {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	self.roll()
+	self.merged.Reset()
+	for _, b := range self.buckets {
+		self.merged.Merge(b)
+	}
+	return self.merged.Quantile(q)
+}
+
+const sketchRelativeAccuracy float64 = .01
+const sketchMaxBins int = 2048
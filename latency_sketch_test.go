@@ -0,0 +1,114 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestLatencySketchQuantile(t *testing.T) {
+	t.Parallel()
+
+	s := newLatencySketch()
+	if q := s.Quantile(.5); q != 0 {
+		t.Fatalf("expected %s but got %s", time.Duration(0), q)
+	}
+	for x := 1; x <= 1000; x = x + 1 {
+		s.Add(time.Duration(x) * time.Millisecond)
+	}
+	tests := []struct {
+		q        float64
+		expected time.Duration
+	}{
+		{q: .5, expected: 500 * time.Millisecond},
+		{q: .9, expected: 900 * time.Millisecond},
+		{q: .99, expected: 990 * time.Millisecond},
+		{q: .999, expected: 999 * time.Millisecond},
+		{q: 1, expected: 1000 * time.Millisecond},
+	}
+	for _, test := range tests {
+		actual := s.Quantile(test.q)
+		margin := time.Duration(float64(test.expected) * sketchRelativeAccuracy)
+		if actual < test.expected-margin || actual > test.expected+margin {
+			t.Fatalf("expected %s within %s but got %s", test.expected, margin, actual)
+		}
+	}
+}
+
+func TestLatencySketchZero(t *testing.T) {
+	t.Parallel()
+
+	s := newLatencySketch()
+	s.Add(0)
+	s.Add(0)
+	s.Add(time.Second)
+	if q := s.Quantile(.5); q != 0 {
+		t.Fatalf("expected %s but got %s", time.Duration(0), q)
+	}
+	if q := s.Quantile(1); q < 990*time.Millisecond || q > 1010*time.Millisecond {
+		t.Fatalf("expected %s but got %s", time.Second, q)
+	}
+}
+
+func TestLatencySketchBounded(t *testing.T) {
+	t.Parallel()
+
+	s := newLatencySketch()
+	var v time.Duration
+	for v = 1; v < math.MaxInt64/2; v = v * 2 {
+		s.Add(v)
+	}
+	s.Add(time.Nanosecond)
+	if len(s.bins) > sketchMaxBins {
+		t.Fatalf("expected at most %d bins but got %d", sketchMaxBins, len(s.bins))
+	}
+	if q := s.Quantile(1); q < v/2-v/100 {
+		t.Fatalf("expected the maximum to be retained but got %s", q)
+	}
+}
+
+func TestLatencySketchMerge(t *testing.T) {
+	t.Parallel()
+
+	a := newLatencySketch()
+	b := newLatencySketch()
+	for x := 1; x <= 50; x = x + 1 {
+		a.Add(time.Duration(x) * time.Millisecond)
+		b.Add(time.Duration(x+50) * time.Millisecond)
+	}
+	a.Merge(b)
+	if a.count != 100 {
+		t.Fatalf("expected %d values but got %d", 100, a.count)
+	}
+	if q := a.Quantile(.9); q < 89*time.Millisecond || q > 91*time.Millisecond {
+		t.Fatalf("expected %s but got %s", 90*time.Millisecond, q)
+	}
+}
+
+func TestLatencySketchWindowRolls(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Unix(0, 0)
+	w := newLatencySketchWindow(5, time.Second)
+	w.now = func() time.Time { return now }
+
+	w.Append(ctx, time.Second)
+	now = now.Add(2 * time.Second)
+	w.Append(ctx, time.Millisecond)
+	if q := w.Quantile(ctx, 1); q < 990*time.Millisecond {
+		t.Fatalf("expected %s but got %s", time.Second, q)
+	}
+	now = now.Add(4 * time.Second)
+	if q := w.Quantile(ctx, 1); q > 2*time.Millisecond {
+		t.Fatalf("expected %s but got %s", time.Millisecond, q)
+	}
+	now = now.Add(10 * time.Second)
+	if q := w.Quantile(ctx, 1); q != 0 {
+		t.Fatalf("expected %s but got %s", time.Duration(0), q)
+	}
+}
@@ -6,6 +6,7 @@ package loadshed
 
 import (
 	"context"
+	"time"
 )
 
 type FailureProbabilityCurve struct {
@@ -40,6 +41,17 @@ func (self *FailureProbabilityCurve) Likelihood(ctx context.Context) float32 {
 	return self.Curve.Curve(ctx, current)
 }
 
+// Evaluate measures the usage of the Capacity once and derives the likelihood
+// from that measurement.
+func (self *FailureProbabilityCurve) Evaluate(ctx context.Context) Decision {
+	usage := self.Capacity.Usage(ctx)
+	return Decision{
+		Name:       self.Capacity.Name(ctx),
+		Usage:      usage,
+		Likelihood: self.Curve.Curve(ctx, usage),
+	}
+}
+
 func (self *FailureProbabilityCurve) Wrap(fn Fn) Fn {
 	if w, ok := self.Capacity.(Wrapper); ok {
 		return w.Wrap(fn)
@@ -47,4 +59,9 @@ func (self *FailureProbabilityCurve) Wrap(fn Fn) Fn {
 	return fn
 }
 
+func (self *FailureProbabilityCurve) RetryAfter(ctx context.Context) time.Duration {
+	return retryAfter(ctx, self.Capacity)
+}
+
 var _ FailureProbability = &FailureProbabilityCurve{}
+var _ Evaluator = &FailureProbabilityCurve{}
@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Probe selects invocations that are admitted despite a probabilistic
+// rejection. Allow is only called for invocations that would otherwise be
+// rejected.
+type Probe interface {
+	Allow(ctx context.Context) bool
+}
+
+// ProbeFN is an adapter for simple probe functions.
+type ProbeFN func(ctx context.Context) bool
+
+func (self ProbeFN) Allow(ctx context.Context) bool {
+	return self(ctx)
+}
+
+type probeCtxKeyType struct{}
+
+var probeCtxKey = probeCtxKeyType{} //nolint: gochecknoglobals
+
+// ProbeFromContext returns true if the invocation was admitted as a probe.
+func ProbeFromContext(ctx context.Context) bool {
+	v := ctx.Value(probeCtxKey)
+	if v == nil {
+		return false
+	}
+	return v.(bool)
+}
+
+// ProbeToContext marks the invocation as a probe.
+func ProbeToContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, probeCtxKey, true)
+}
+
+// ProbeFraction admits a minimum fraction of all rejected invocations. For
+// example, a fraction of .01 admits roughly one percent of invocations even
+// when the rejection rate is 100%.
+type ProbeFraction struct {
+	fraction  float32
+	randFloat func() float32
+}
+
+func NewProbeFraction(fraction float32) *ProbeFraction {
+	return &ProbeFraction{
+		fraction:  fraction,
+		randFloat: rand.Float32,
+	}
+}
+
+func (self *ProbeFraction) Allow(context.Context) bool {
+	return self.randFloat() < self.fraction
+}
+
+// ProbeInterval admits a fixed number of rejected invocations within each
+// interval of time. For example, a count of 5 and an interval of 1s admits up
+// to five invocations each second regardless of the rejection rate.
+type ProbeInterval struct {
+	count    int
+	interval time.Duration
+	lock     *sync.Mutex
+	start    time.Time
+	allowed  int
+	now      func() time.Time
+}
+
+func NewProbeInterval(count int, interval time.Duration) *ProbeInterval {
+	return &ProbeInterval{
+		count:    count,
+		interval: interval,
+		lock:     &sync.Mutex{},
+		now:      time.Now,
+	}
+}
+
+func (self *ProbeInterval) Allow(context.Context) bool {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	now := self.now()
+	if now.Sub(self.start) >= self.interval {
+		self.start = now
+		self.allowed = 0
+	}
+	if self.allowed >= self.count {
+		return false
+	}
+	self.allowed = self.allowed + 1
+	return true
+}
+
+var _ Probe = ProbeFN(nil)
+var _ Probe = &ProbeFraction{}
+var _ Probe = &ProbeInterval{}
@@ -0,0 +1,156 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestProbeFraction(t *testing.T) {
+	t.Parallel()
+
+	p := NewProbeFraction(.1)
+	p.randFloat = fixedRandom(.05, .5)
+	ctx := context.Background()
+	if !p.Allow(ctx) {
+		t.Fatal("expected the probe to be allowed")
+	}
+	if p.Allow(ctx) {
+		t.Fatal("expected the probe to be denied")
+	}
+}
+
+func TestProbeInterval(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	p := NewProbeInterval(2, time.Second)
+	p.now = func() time.Time { return now }
+	ctx := context.Background()
+	for x := 0; x < 2; x = x + 1 {
+		if !p.Allow(ctx) {
+			t.Fatalf("expected probe %d to be allowed", x)
+		}
+	}
+	if p.Allow(ctx) {
+		t.Fatal("expected the probe to be denied after the count was reached")
+	}
+	now = now.Add(time.Second)
+	if !p.Allow(ctx) {
+		t.Fatal("expected the probe to be allowed in the next interval")
+	}
+}
+
+func TestShedderProbe(t *testing.T) {
+	t.Parallel()
+
+	shed := NewShedder(
+		OptionShedderRejectionRate(&staticCountingRate{value: 1}),
+		OptionShedderProbe(NewProbeInterval(1, time.Hour)),
+	)
+	ctx := context.Background()
+	probed := false
+	err := shed.Do(ctx, func(ctx context.Context) error {
+		probed = ProbeFromContext(ctx)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if !probed {
+		t.Fatal("expected the invocation to be marked as a probe")
+	}
+	err = shed.Do(ctx, func(ctx context.Context) error {
+		return nil
+	})
+	var e ErrRejection
+	if !errors.As(err, &e) {
+		t.Fatalf("expected ErrRejection but got %v", err)
+	}
+}
+
+func TestShedderProbeIgnoresRules(t *testing.T) {
+	t.Parallel()
+
+	shed := NewShedder(
+		OptionShedderRule(&staticRule{reject: true}),
+		OptionShedderProbe(ProbeFN(func(ctx context.Context) bool { return true })),
+	)
+	err := shed.Do(context.Background(), func(ctx context.Context) error {
+		return nil
+	})
+	var e ErrRejection
+	if !errors.As(err, &e) {
+		t.Fatalf("expected ErrRejection but got %v", err)
+	}
+}
+
+func TestShedderNoProbeForAdmitted(t *testing.T) {
+	t.Parallel()
+
+	shed := NewShedder(
+		OptionShedderRejectionRate(&staticCountingRate{value: 0}),
+		OptionShedderProbe(ProbeFN(func(ctx context.Context) bool { return true })),
+	)
+	probed := true
+	_ = shed.Do(context.Background(), func(ctx context.Context) error {
+		probed = ProbeFromContext(ctx)
+		return nil
+	})
+	if probed {
+		t.Fatal("expected an admitted invocation to not be marked as a probe")
+	}
+}
+
+func TestShedderDoNProbe(t *testing.T) {
+	t.Parallel()
+
+	shed := NewShedder(
+		OptionShedderRejectionRate(&staticCountingRate{value: 1}),
+		OptionShedderProbe(NewProbeInterval(2, time.Hour)),
+	)
+	var admitted []int
+	var probes []bool
+	_, err := shed.DoN(context.Background(), []Classification{"", "", ""}, func(ctx context.Context, a []int, p []bool) error {
+		admitted = a
+		probes = p
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if len(admitted) != 2 || len(probes) != 2 {
+		t.Fatalf("expected %d admitted probes but got %v %v", 2, admitted, probes)
+	}
+	if !probes[0] || !probes[1] {
+		t.Fatalf("expected every admitted item to be marked as a probe but got %v", probes)
+	}
+}
+
+func TestShedderDoNParentProbe(t *testing.T) {
+	t.Parallel()
+
+	parent := NewShedder(
+		OptionShedderRejectionRate(&staticCountingRate{value: 1}),
+		OptionShedderProbe(NewProbeInterval(1, time.Hour)),
+	)
+	child := NewShedder(
+		OptionShedderParent(parent),
+		OptionShedderRejectionRate(&staticCountingRate{value: 0}),
+	)
+	var probes []bool
+	_, err := child.DoN(context.Background(), []Classification{"", ""}, func(ctx context.Context, _ []int, p []bool) error {
+		probes = p
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if len(probes) != 1 || !probes[0] {
+		t.Fatalf("expected one item admitted as a probe by the parent but got %v", probes)
+	}
+}
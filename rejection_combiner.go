@@ -0,0 +1,157 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+)
+
+// RejectionCombiner determines how the rates of multiple RejectionRate
+// policies are combined into a single rejection decision.
+type RejectionCombiner interface {
+	// Combine is given the current rate of each RejectionRate, in the order
+	// they were added to the Shedder, and a source of random values between 0
+	// and 1. It returns whether the invocation should be rejected, the
+	// effective rejection rate, and the index of the rate that is primarily
+	// responsible for the decision. The index is only used when rejecting.
+	//
+	// Each random value is drawn for a purpose identified by the given offset.
+	// An offset of a rate draws a value that is compared against that rate and
+	// RollCombined draws a value that is compared against the combination of
+	// all rates. The Shedder reports each value as the Roll of the Decision of
+	// the rate it was drawn for, or of every Decision for RollCombined.
+	Combine(ctx context.Context, rates []float32, random func(offset int) float32) (bool, float32, int)
+}
+
+// RollCombined is the offset given to the random function of a
+// RejectionCombiner to draw a value that applies to the combination of all
+// rates rather than to a single rate.
+const RollCombined int = -1
+
+// RejectionCombinerFN is an adapter for simple combining functions.
+type RejectionCombinerFN func(ctx context.Context, rates []float32, random func(offset int) float32) (bool, float32, int)
+
+func (self RejectionCombinerFN) Combine(ctx context.Context, rates []float32, random func(offset int) float32) (bool, float32, int) {
+	return self(ctx, rates, random)
+}
+
+// RejectionCombinerSequential applies each rate independently and in order
+// with a separate random value for each. The first rate to reject the
+// invocation is reported as the primary rate.
+//
+// This is the default behavior of the Shedder. Note that the effective
+// rejection rate compounds with each additional rate. For example, three
+// rates of 30% result in an effective rejection rate of roughly 66%.
+type RejectionCombinerSequential struct{}
+
+func NewRejectionCombinerSequential() *RejectionCombinerSequential {
+	return &RejectionCombinerSequential{}
+}
+
+func (*RejectionCombinerSequential) Combine(ctx context.Context, rates []float32, random func(offset int) float32) (bool, float32, int) {
+	for offset, rate := range rates {
+		if random(offset) < rate {
+			return true, rate, offset
+		}
+	}
+	return false, probabilisticOr(rates), -1
+}
+
+// RejectionCombinerMax uses the largest of all rates as the effective
+// rejection rate.
+type RejectionCombinerMax struct{}
+
+func NewRejectionCombinerMax() *RejectionCombinerMax {
+	return &RejectionCombinerMax{}
+}
+
+func (*RejectionCombinerMax) Combine(ctx context.Context, rates []float32, random func(offset int) float32) (bool, float32, int) {
+	rate, offset := maxRate(rates, nil)
+	return random(RollCombined) < rate, rate, offset
+}
+
+// RejectionCombinerWeightedSum multiplies each rate by a weight and uses the
+// sum, bounded to 1, as the effective rejection rate. Weights are matched to
+// rates by position and any rate without a matching weight is given a weight
+// of 1. The rate with the largest weighted value is reported as the primary
+// rate.
+type RejectionCombinerWeightedSum struct {
+	weights []float32
+}
+
+func NewRejectionCombinerWeightedSum(weights ...float32) *RejectionCombinerWeightedSum {
+	return &RejectionCombinerWeightedSum{
+		weights: weights,
+	}
+}
+
+func (self *RejectionCombinerWeightedSum) Combine(ctx context.Context, rates []float32, random func(offset int) float32) (bool, float32, int) {
+	var rate float32
+	for offset, r := range rates {
+		rate = rate + r*weightAt(self.weights, offset)
+	}
+	if rate > 1 {
+		rate = 1
+	}
+	_, offset := maxRate(rates, self.weights)
+	return random(RollCombined) < rate, rate, offset
+}
+
+// RejectionCombinerProbabilisticOr treats each rate as an independent chance
+// of rejection and uses the probability that any of them would reject, or
+// 1 - (1 - r1) * (1 - r2) * ..., as the effective rejection rate. The result
+// is bounded by a maximum value. Unlike RejectionCombinerSequential, only a
+// single random value is used. The largest rate is reported as the primary
+// rate.
+type RejectionCombinerProbabilisticOr struct {
+	limit float32
+}
+
+func NewRejectionCombinerProbabilisticOr(limit float32) *RejectionCombinerProbabilisticOr {
+	return &RejectionCombinerProbabilisticOr{
+		limit: limit,
+	}
+}
+
+func (self *RejectionCombinerProbabilisticOr) Combine(ctx context.Context, rates []float32, random func(offset int) float32) (bool, float32, int) {
+	rate := probabilisticOr(rates)
+	if rate > self.limit {
+		rate = self.limit
+	}
+	_, offset := maxRate(rates, nil)
+	return random(RollCombined) < rate, rate, offset
+}
+
+func probabilisticOr(rates []float32) float32 {
+	var pass float32 = 1
+	for _, rate := range rates {
+		pass = pass * (1 - rate)
+	}
+	return 1 - pass
+}
+
+func maxRate(rates []float32, weights []float32) (float32, int) {
+	var result float32
+	index := -1
+	for offset, rate := range rates {
+		rate = rate * weightAt(weights, offset)
+		if index < 0 || rate > result {
+			result = rate
+			index = offset
+		}
+	}
+	return result, index
+}
+
+func weightAt(weights []float32, offset int) float32 {
+	if offset < len(weights) {
+		return weights[offset]
+	}
+	return 1
+}
+
+var _ RejectionCombiner = &RejectionCombinerSequential{}
+var _ RejectionCombiner = &RejectionCombinerMax{}
+var _ RejectionCombiner = &RejectionCombinerWeightedSum{}
+var _ RejectionCombiner = &RejectionCombinerProbabilisticOr{}
@@ -0,0 +1,237 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRejectionCombiners(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		combiner RejectionCombiner
+		rates    []float32
+		rolls    []float32
+		reject   bool
+		rate     float32
+		index    int
+	}{
+		{
+			name:     "sequential first",
+			combiner: NewRejectionCombinerSequential(),
+			rates:    []float32{.3, .3, .3},
+			rolls:    []float32{.1},
+			reject:   true,
+			rate:     .3,
+			index:    0,
+		},
+		{
+			name:     "sequential last",
+			combiner: NewRejectionCombinerSequential(),
+			rates:    []float32{.3, .3, .3},
+			rolls:    []float32{.5, .5, .1},
+			reject:   true,
+			rate:     .3,
+			index:    2,
+		},
+		{
+			name:     "sequential none",
+			combiner: NewRejectionCombinerSequential(),
+			rates:    []float32{.3, .3, .3},
+			rolls:    []float32{.5, .5, .5},
+			reject:   false,
+			rate:     .657,
+			index:    -1,
+		},
+		{
+			name:     "max",
+			combiner: NewRejectionCombinerMax(),
+			rates:    []float32{.3, .6, .3},
+			rolls:    []float32{.5},
+			reject:   true,
+			rate:     .6,
+			index:    1,
+		},
+		{
+			name:     "max none",
+			combiner: NewRejectionCombinerMax(),
+			rates:    []float32{.3, .6, .3},
+			rolls:    []float32{.7},
+			reject:   false,
+			rate:     .6,
+			index:    1,
+		},
+		{
+			name:     "weighted sum",
+			combiner: NewRejectionCombinerWeightedSum(.5, .25),
+			rates:    []float32{.4, .4, .1},
+			rolls:    []float32{.3},
+			reject:   true,
+			rate:     .4,
+			index:    0,
+		},
+		{
+			name:     "weighted sum bounded",
+			combiner: NewRejectionCombinerWeightedSum(),
+			rates:    []float32{.6, .7},
+			rolls:    []float32{.99},
+			reject:   true,
+			rate:     1,
+			index:    1,
+		},
+		{
+			name:     "probabilistic or",
+			combiner: NewRejectionCombinerProbabilisticOr(1),
+			rates:    []float32{.3, .3, .3},
+			rolls:    []float32{.6},
+			reject:   true,
+			rate:     .657,
+			index:    0,
+		},
+		{
+			name:     "probabilistic or capped",
+			combiner: NewRejectionCombinerProbabilisticOr(.5),
+			rates:    []float32{.3, .3, .3},
+			rolls:    []float32{.6},
+			reject:   false,
+			rate:     .5,
+			index:    0,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			reject, rate, index := test.combiner.Combine(ctx, test.rates, offsetRandom(fixedRandom(test.rolls...)))
+			if reject != test.reject {
+				t.Fatalf("expected reject %t but got %t", test.reject, reject)
+			}
+			if rate < test.rate-.001 || rate > test.rate+.001 {
+				t.Fatalf("expected rate %f but got %f", test.rate, rate)
+			}
+			if index != test.index {
+				t.Fatalf("expected index %d but got %d", test.index, index)
+			}
+		})
+	}
+}
+
+func TestShedderDoRejectionCombinerContributions(t *testing.T) {
+	t.Parallel()
+
+	shed := NewShedder(
+		OptionShedderRejectionRate(&staticCountingRate{value: .3}),
+		OptionShedderRejectionRate(&staticCountingRate{value: 0}),
+		OptionShedderRejectionRate(&staticCountingRate{value: .6}),
+		OptionShedderRejectionCombiner(NewRejectionCombinerMax()),
+		OptionShedderRandom(fixedRandom(.5)),
+	)
+	ctx := context.Background()
+	fn := func(ctx context.Context) error {
+		return nil
+	}
+
+	err := shed.Do(ctx, fn)
+	var e ErrRejection
+	if !errors.As(err, &e) {
+		t.Fatalf("expected ErrRejection but got %v", err)
+	}
+	if e.Rate != .6 {
+		t.Fatalf("expected rate %f but got %f", .6, e.Rate)
+	}
+	if e.Usage != .6 {
+		t.Fatalf("expected primary usage %f but got %f", .6, e.Usage)
+	}
+	if len(e.Contributions) != 2 {
+		t.Fatalf("expected %d contributions but got %d", 2, len(e.Contributions))
+	}
+	if e.Contributions[0].Rate != .3 || e.Contributions[1].Rate != .6 {
+		t.Fatalf("unexpected contributions %v", e.Contributions)
+	}
+}
+
+func TestShedderDoRejectionCombinerRolls(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		combiner RejectionCombiner
+		rolls    []float32
+		expected []float32
+		roll     float32
+	}{
+		{name: "sequential", combiner: NewRejectionCombinerSequential(), rolls: []float32{.5, .6}, expected: []float32{.5, .6, 0}, roll: .6},
+		{name: "max", combiner: NewRejectionCombinerMax(), rolls: []float32{.5}, expected: []float32{.5, .5, .5}, roll: .5},
+		{
+			// A custom combiner that applies only the last rate.
+			name: "custom",
+			combiner: RejectionCombinerFN(func(ctx context.Context, rates []float32, random func(offset int) float32) (bool, float32, int) {
+				last := len(rates) - 1
+				return random(last) < rates[last], rates[last], last
+			}),
+			rolls:    []float32{.3},
+			expected: []float32{0, 0, .3},
+			roll:     .3,
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			shed := NewShedder(
+				OptionShedderRejectionRate(&staticCountingRate{value: .2}),
+				OptionShedderRejectionRate(&staticCountingRate{value: .9}),
+				OptionShedderRejectionRate(&staticCountingRate{value: .4}),
+				OptionShedderRejectionCombiner(test.combiner),
+				OptionShedderRandom(fixedRandom(test.rolls...)),
+			)
+			err := shed.Do(context.Background(), func(ctx context.Context) error {
+				return nil
+			})
+			var e ErrRejection
+			if !errors.As(err, &e) {
+				t.Fatalf("expected ErrRejection but got %v", err)
+			}
+			if e.Roll != test.roll {
+				t.Fatalf("expected roll %f but got %f", test.roll, e.Roll)
+			}
+			if len(e.Contributions) != len(test.expected) {
+				t.Fatalf("expected %d contributions but got %d", len(test.expected), len(e.Contributions))
+			}
+			for offset, roll := range test.expected {
+				if e.Contributions[offset].Roll != roll {
+					t.Fatalf("expected roll %f for rate %d but got %f", roll, offset, e.Contributions[offset].Roll)
+				}
+			}
+		})
+	}
+}
+
+// offsetRandom adapts a random function to the form given to a
+// RejectionCombiner.
+func offsetRandom(random func() float32) func(offset int) float32 {
+	return func(int) float32 {
+		return random()
+	}
+}
+
+// fixedRandom returns a random function that produces the given values in
+// order and then repeats the final value.
+func fixedRandom(values ...float32) func() float32 {
+	offset := 0
+	return func() float32 {
+		v := values[offset]
+		if offset < len(values)-1 {
+			offset = offset + 1
+		}
+		return v
+	}
+}
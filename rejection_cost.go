@@ -0,0 +1,61 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// RejectionRateCost scales a RejectionRate by the cost of each invocation so
+// that expensive invocations are rejected earlier than cheap ones. The rate of
+// an invocation with cost c is the chance that at least one of c invocations
+// with unit cost would be rejected, or 1-(1-rate)^c. Invocations with a cost of
+// 1 use the underlying rate and invocations with a cost of 0 are never
+// rejected by the rate.
+type RejectionRateCost struct {
+	RejectionRate
+}
+
+func NewRejectionRateCost(rate RejectionRate) *RejectionRateCost {
+	return &RejectionRateCost{
+		RejectionRate: rate,
+	}
+}
+
+func (self *RejectionRateCost) Rate(ctx context.Context) float32 {
+	return self.scale(ctx, self.RejectionRate.Rate(ctx))
+}
+
+func (self *RejectionRateCost) Evaluate(ctx context.Context) Decision {
+	d := evaluateRate(ctx, self.RejectionRate)
+	d.Rate = self.scale(ctx, d.Rate)
+	return d
+}
+
+func (self *RejectionRateCost) scale(ctx context.Context, rate float32) float32 {
+	cost := CostFromContext(ctx)
+	if cost == 0 {
+		return 0
+	}
+	if cost == 1 || rate <= 0 || rate >= 1 {
+		return rate
+	}
+	return float32(1 - math.Pow(1-float64(rate), float64(cost)))
+}
+
+func (self *RejectionRateCost) Wrap(fn Fn) Fn {
+	if w, ok := self.RejectionRate.(Wrapper); ok {
+		return w.Wrap(fn)
+	}
+	return fn
+}
+
+func (self *RejectionRateCost) RetryAfter(ctx context.Context) time.Duration {
+	return retryAfter(ctx, self.RejectionRate)
+}
+
+var _ RejectionRate = &RejectionRateCost{}
+var _ Evaluator = &RejectionRateCost{}
@@ -0,0 +1,45 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"testing"
+)
+
+func TestRejectionRateCost(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		rate     float32
+		cost     int
+		expected float32
+		noCost   bool
+	}{
+		{name: "default cost", rate: .1, noCost: true, expected: .1},
+		{name: "unit cost", rate: .1, cost: 1, expected: .1},
+		{name: "zero cost", rate: .1, cost: 0, expected: 0},
+		{name: "zero cost full rate", rate: 1, cost: 0, expected: 0},
+		{name: "high cost", rate: .1, cost: 10, expected: .6513},
+		{name: "zero rate", rate: 0, cost: 10, expected: 0},
+		{name: "full rate", rate: 1, cost: 10, expected: 1},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := context.Background()
+			if !test.noCost {
+				ctx = CostToContext(ctx, test.cost)
+			}
+			r := NewRejectionRateCost(&staticCountingRate{value: test.rate})
+			rate := r.Rate(ctx)
+			if rate < test.expected-.001 || rate > test.expected+.001 {
+				t.Fatalf("expected rate %f but got %f", test.expected, rate)
+			}
+		})
+	}
+}
@@ -6,6 +6,7 @@ package loadshed
 
 import (
 	"context"
+	"time"
 )
 
 type RejectionRateCurve struct {
@@ -51,12 +52,24 @@ func NewRejectionRateCurveByClassification(probability FailureProbability, defau
 }
 
 func (self *RejectionRateCurve) Rate(ctx context.Context) float32 {
+	current := self.FailureProbability.Likelihood(ctx)
+	return self.curve(ctx).Curve(ctx, current)
+}
+
+// Evaluate derives the rate from a single evaluation of the
+// FailureProbability.
+func (self *RejectionRateCurve) Evaluate(ctx context.Context) Decision {
+	d := evaluateProbability(ctx, self.FailureProbability)
+	d.Rate = self.curve(ctx).Curve(ctx, d.Likelihood)
+	return d
+}
+
+func (self *RejectionRateCurve) curve(ctx context.Context) Curve {
 	curve := self.classificationCurves[ClassificationFromContext(ctx)]
 	if curve == nil {
 		curve = self.defaultCurve
 	}
-	current := self.FailureProbability.Likelihood(ctx)
-	return curve.Curve(ctx, current)
+	return curve
 }
 
 func (self *RejectionRateCurve) Wrap(fn Fn) Fn {
@@ -66,4 +79,9 @@ func (self *RejectionRateCurve) Wrap(fn Fn) Fn {
 	return fn
 }
 
+func (self *RejectionRateCurve) RetryAfter(ctx context.Context) time.Duration {
+	return retryAfter(ctx, self.FailureProbability)
+}
+
 var _ RejectionRate = &RejectionRateCurve{}
+var _ Evaluator = &RejectionRateCurve{}
@@ -0,0 +1,122 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type OptionSlew func(*RejectionRateSlew)
+
+// OptionSlewHysteresis sets a band around the current rate within which
+// changes of the underlying rate are ignored. For example, a band of .05 holds
+// a rate of .3 until the underlying rate rises above .35 or falls below .25.
+// The band does not apply when the underlying rate reaches 0 or 1 so that the
+// rate can always settle at either limit. The default band is 0.
+func OptionSlewHysteresis(band float32) OptionSlew {
+	return func(rs *RejectionRateSlew) {
+		rs.band = band
+	}
+}
+
+// RejectionRateSlew is a wrapper for other RejectionRate implementations that
+// limits how quickly the rejection rate changes. This smooths the rejection
+// rate when the underlying rate oscillates, such as when a capacity hovers
+// near the lower bound of a CurveLinear.
+//
+// The rate moves toward the underlying rate by no more than the attack value
+// per second when rising and no more than the release value per second when
+// falling. A rate that is not positive disables the limit in that direction.
+// The rate of each classification is tracked independently and starts at 0.
+type RejectionRateSlew struct {
+	RejectionRate
+	attack  float32
+	release float32
+	band    float32
+	lock    *sync.Mutex
+	states  map[Classification]*slewState
+	now     func() time.Time
+}
+
+type slewState struct {
+	value float32
+	last  time.Time
+}
+
+// NewRejectionRateSlew wraps the RejectionRate with the given attack and
+// release values measured as a change in rate per second. For example, an
+// attack of .5 takes at least two seconds to go from no rejection to full
+// rejection.
+func NewRejectionRateSlew(rate RejectionRate, attack float32, release float32, options ...OptionSlew) *RejectionRateSlew {
+	r := &RejectionRateSlew{
+		RejectionRate: rate,
+		attack:        attack,
+		release:       release,
+		lock:          &sync.Mutex{},
+		states:        map[Classification]*slewState{},
+		now:           time.Now,
+	}
+	for _, opt := range options {
+		opt(r)
+	}
+	return r
+}
+
+func (self *RejectionRateSlew) Rate(ctx context.Context) float32 {
+	return self.step(ctx, self.RejectionRate.Rate(ctx))
+}
+
+func (self *RejectionRateSlew) Evaluate(ctx context.Context) Decision {
+	d := evaluateRate(ctx, self.RejectionRate)
+	d.Rate = self.step(ctx, d.Rate)
+	return d
+}
+
+// step moves the rate of the current classification toward the target.
+func (self *RejectionRateSlew) step(ctx context.Context, target float32) float32 {
+	class := ClassificationFromContext(ctx)
+	now := self.now()
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	state, ok := self.states[class]
+	if !ok {
+		state = &slewState{last: now}
+		self.states[class] = state
+	}
+	elapsed := float32(now.Sub(state.last).Seconds())
+	state.last = now
+	diff := target - state.value
+	if target > 0 && target < 1 && diff < self.band && diff > -self.band {
+		return state.value
+	}
+	switch {
+	case diff > 0:
+		if step := self.attack * elapsed; self.attack > 0 && step < diff {
+			diff = step
+		}
+	case diff < 0:
+		if step := self.release * elapsed; self.release > 0 && step < -diff {
+			diff = -step
+		}
+	}
+	state.value = state.value + diff
+	return state.value
+}
+
+func (self *RejectionRateSlew) Wrap(fn Fn) Fn {
+	if w, ok := self.RejectionRate.(Wrapper); ok {
+		return w.Wrap(fn)
+	}
+	return fn
+}
+
+func (self *RejectionRateSlew) RetryAfter(ctx context.Context) time.Duration {
+	return retryAfter(ctx, self.RejectionRate)
+}
+
+var _ RejectionRate = &RejectionRateSlew{}
+var _ Evaluator = &RejectionRateSlew{}
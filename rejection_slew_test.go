@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRejectionRateSlew(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	now := time.Now()
+	inner := &staticCountingRate{value: 1}
+	r := NewRejectionRateSlew(inner, 1, .5, OptionSlewHysteresis(.1))
+	r.now = func() time.Time { return now }
+
+	steps := []struct {
+		name     string
+		elapsed  time.Duration
+		target   float32
+		expected float32
+	}{
+		{name: "initial", elapsed: 0, target: 1, expected: 0},
+		{name: "attack", elapsed: 200 * time.Millisecond, target: 1, expected: .2},
+		{name: "attack limit", elapsed: 2 * time.Second, target: .4, expected: .4},
+		{name: "within band", elapsed: time.Second, target: .45, expected: .4},
+		{name: "within band falling", elapsed: time.Second, target: .35, expected: .4},
+		{name: "release", elapsed: 200 * time.Millisecond, target: .2, expected: .3},
+		{name: "release to zero", elapsed: 2 * time.Second, target: 0, expected: 0},
+	}
+	for _, step := range steps {
+		now = now.Add(step.elapsed)
+		inner.value = step.target
+		rate := r.Rate(ctx)
+		if rate < step.expected-.001 || rate > step.expected+.001 {
+			t.Fatalf("%s: expected rate %f but got %f", step.name, step.expected, rate)
+		}
+	}
+}
+
+func TestRejectionRateSlewByClassification(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	inner := &classRate{rates: map[Classification]float32{"LOW": 1, "HIGH": 1}}
+	r := NewRejectionRateSlew(inner, 1, 1)
+	r.now = func() time.Time { return now }
+	low := ClassificationToContext(context.Background(), "LOW")
+	high := ClassificationToContext(context.Background(), "HIGH")
+
+	_ = r.Rate(low)
+	now = now.Add(500 * time.Millisecond)
+	if rate := r.Rate(low); rate != .5 {
+		t.Fatalf("expected rate %f but got %f", .5, rate)
+	}
+	if rate := r.Rate(high); rate != 0 {
+		t.Fatalf("expected rate %f but got %f", 0.0, rate)
+	}
+}
+
+func TestRejectionRateSlewUnlimited(t *testing.T) {
+	t.Parallel()
+
+	inner := &staticCountingRate{value: .7}
+	r := NewRejectionRateSlew(inner, 0, 0)
+	if rate := r.Rate(context.Background()); rate != .7 {
+		t.Fatalf("expected rate %f but got %f", .7, rate)
+	}
+}
@@ -0,0 +1,116 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"time"
+)
+
+type OptionDeadline func(*RuleDeadline)
+
+func OptionDeadlineName(name string) OptionDeadline {
+	return func(rd *RuleDeadline) {
+		rd.name = name
+	}
+}
+
+// OptionDeadlineMargin adds a fixed amount of time to the predicted latency
+// before it is compared to the remaining time of the context. The default is
+// zero.
+func OptionDeadlineMargin(d time.Duration) OptionDeadline {
+	return func(rd *RuleDeadline) {
+		rd.margin = d
+	}
+}
+
+// OptionDeadlineMeasure controls whether the rule records the latency of each
+// admitted invocation into the CapacityLatency used for its classification.
+// The default is true. Disable this when the same CapacityLatency is also
+// installed elsewhere in the Shedder, such as in a RejectionRate, so that each
+// invocation is only recorded once.
+func OptionDeadlineMeasure(v bool) OptionDeadline {
+	return func(rd *RuleDeadline) {
+		rd.measure = v
+	}
+}
+
+// RuleDeadline rejects invocations whose context deadline is expected to
+// expire before the invocation can complete. The expected duration of an
+// invocation is predicted by the Latency of a CapacityLatency, such as the
+// recent p90 when the capacity is configured with a percentile reduction.
+//
+// Invocations without a deadline are always admitted as are all invocations
+// while there is no latency data available.
+type RuleDeadline struct {
+	name      string
+	latency   *CapacityLatency
+	latencies map[Classification]*CapacityLatency
+	margin    time.Duration
+	measure   bool
+	now       func() time.Time
+}
+
+// NewRuleDeadline creates a rule that predicts the latency of all invocations
+// using the given CapacityLatency.
+func NewRuleDeadline(latency *CapacityLatency, options ...OptionDeadline) *RuleDeadline {
+	return NewRuleDeadlineByClassification(latency, map[Classification]*CapacityLatency{}, options...)
+}
+
+// NewRuleDeadlineByClassification allows for the latency of an invocation to
+// be predicted based on its classification. Invocations without a matching
+// classification use the default CapacityLatency.
+func NewRuleDeadlineByClassification(defaultLatency *CapacityLatency, classes map[Classification]*CapacityLatency, options ...OptionDeadline) *RuleDeadline {
+	r := &RuleDeadline{
+		name:      defaultNameDeadline,
+		latency:   defaultLatency,
+		latencies: classes,
+		measure:   true,
+		now:       time.Now,
+	}
+	for _, opt := range options {
+		opt(r)
+	}
+	return r
+}
+
+func (self *RuleDeadline) Name(context.Context) string {
+	return self.name
+}
+
+// Reject returns true if the time remaining before the context deadline is
+// less than the predicted latency plus the margin.
+func (self *RuleDeadline) Reject(ctx context.Context) bool {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return false
+	}
+	predicted := self.latencyFor(ctx).Latency(ctx)
+	if predicted <= 0 {
+		return false
+	}
+	return deadline.Sub(self.now()) < predicted+self.margin
+}
+
+// Wrap records the latency of the invocation if measuring is enabled.
+func (self *RuleDeadline) Wrap(fn Fn) Fn {
+	if !self.measure {
+		return fn
+	}
+	return func(ctx context.Context) error {
+		return self.latencyFor(ctx).Wrap(fn)(ctx)
+	}
+}
+
+func (self *RuleDeadline) latencyFor(ctx context.Context) *CapacityLatency {
+	if l, ok := self.latencies[ClassificationFromContext(ctx)]; ok {
+		return l
+	}
+	return self.latency
+}
+
+const defaultNameDeadline string = "DEADLINE"
+
+var _ Rule = &RuleDeadline{}
+var _ Wrapper = &RuleDeadline{}
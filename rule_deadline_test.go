@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRuleDeadline(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	latency := NewCapacityLatency(time.Second)
+	high := NewCapacityLatency(time.Second)
+	r := NewRuleDeadlineByClassification(latency, map[Classification]*CapacityLatency{
+		"HIGH": high,
+	}, OptionDeadlineMargin(10*time.Millisecond))
+	r.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	latency.Append(ctx, 100*time.Millisecond)
+	high.Append(ctx, 10*time.Millisecond)
+
+	tests := []struct {
+		name      string
+		deadline  time.Duration
+		class     Classification
+		noLimit   bool
+		rejection bool
+	}{
+		{name: "no deadline", noLimit: true, rejection: false},
+		{name: "enough time", deadline: 200 * time.Millisecond, rejection: false},
+		{name: "not enough time", deadline: 50 * time.Millisecond, rejection: true},
+		{name: "within margin", deadline: 105 * time.Millisecond, rejection: true},
+		{name: "class enough time", deadline: 50 * time.Millisecond, class: "HIGH", rejection: false},
+		{name: "class not enough time", deadline: 15 * time.Millisecond, class: "HIGH", rejection: true},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx := ClassificationToContext(context.Background(), test.class)
+			if !test.noLimit {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithDeadline(ctx, now.Add(test.deadline))
+				defer cancel()
+			}
+			if rejection := r.Reject(ctx); rejection != test.rejection {
+				t.Fatalf("expected rejection %t but got %t", test.rejection, rejection)
+			}
+		})
+	}
+}
+
+func TestRuleDeadlineNoData(t *testing.T) {
+	t.Parallel()
+
+	r := NewRuleDeadline(NewCapacityLatency(time.Second))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	if r.Reject(ctx) {
+		t.Fatal("expected no rejection without latency data")
+	}
+}
+
+func TestRuleDeadlineShedder(t *testing.T) {
+	t.Parallel()
+
+	latency := NewCapacityLatency(time.Second)
+	shed := NewShedder(
+		OptionShedderRule(NewRuleDeadline(latency)),
+	)
+	ctx := context.Background()
+	err := shed.Do(ctx, func(ctx context.Context) error {
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if latency.Latency(ctx) < 10*time.Millisecond {
+		t.Fatalf("expected the rule to record latency but got %s", latency.Latency(ctx))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	err = shed.Do(ctx, func(ctx context.Context) error {
+		return nil
+	})
+	var e ErrRejection
+	if !errors.As(err, &e) {
+		t.Fatalf("expected ErrRejection but got %v", err)
+	}
+	if e.Rule != defaultNameDeadline {
+		t.Fatalf("expected rule %s but got %s", defaultNameDeadline, e.Rule)
+	}
+}
@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// sampler calls a function on a fixed interval from a background goroutine.
+// Capacities that read from expensive sources, such as the filesystem, use a
+// sampler so that Usage never performs the read itself.
+type sampler struct {
+	stop chan struct{}
+	done chan struct{}
+	once *sync.Once
+}
+
+// startSampler calls the function once before returning and then on every
+// interval until the sampler is stopped.
+func startSampler(interval time.Duration, fn func()) *sampler {
+	s := &sampler{
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+		once: &sync.Once{},
+	}
+	fn()
+	go func() {
+		defer close(s.done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				fn()
+			}
+		}
+	}()
+	return s
+}
+
+// Stop ends the background goroutine and waits for it to exit. It is safe to
+// call multiple times.
+func (self *sampler) Stop() {
+	self.once.Do(func() {
+		close(self.stop)
+		<-self.done
+	})
+}
+
+// defaultSampleInterval is the default interval of capacities that use a
+// sampler.
+const defaultSampleInterval time.Duration = time.Second
+
+// atomicFloat32 stores a float32 that may be read and written concurrently.
+type atomicFloat32 struct {
+	bits atomic.Uint32
+}
+
+func (self *atomicFloat32) Load() float32 {
+	return math.Float32frombits(self.bits.Load())
+}
+
+func (self *atomicFloat32) Store(v float32) {
+	self.bits.Store(math.Float32bits(v))
+}
@@ -8,6 +8,9 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // ErrRejection provides the details on why an invocation was rejected.
@@ -31,38 +34,205 @@ type ErrRejection struct {
 	// Rate is present when Rule matches RuleProbabilistic and contains the
 	// current rejection rate as derived from the probability of failure.
 	Rate float32
+	// Roll is present when Rule matches RuleProbabilistic and contains the
+	// random value that resulted in the rejection.
+	Roll float32
+	// Contributions is present when Rule matches RuleProbabilistic and
+	// contains the Decision of every RejectionRate with a non-zero rate at the
+	// time of rejection. The Name, Usage, and Likelihood fields contain the
+	// details of the primary rate as determined by the RejectionCombiner while
+	// the Rate field contains the effective rate.
+	Contributions []Decision
+	// RetryAfter is an optional hint for how long the caller should wait
+	// before trying again. It is set when the rejecting Rule or the primary
+	// RejectionRate implements RetryAdvisor and is otherwise zero.
+	RetryAfter time.Duration
+	// Path identifies the Shedder that produced the rejection when Shedders
+	// are composed with OptionShedderParent. It contains the names given by
+	// OptionShedderName from the root Shedder to the rejecting Shedder joined
+	// by a slash, such as "global/api/db". Unnamed Shedders are omitted.
+	Path string
+	// DryRun is set when the rejection was produced by a Shedder operating in
+	// dry-run mode. These rejections are only reported to observers and the
+	// invocation is admitted regardless.
+	DryRun bool
+}
+
+// Decision is a snapshot of the values of a single FailureProbability or
+// RejectionRate. See Evaluator.
+//
+// Roll is set by the Shedder and contains the random value that the
+// RejectionCombiner drew for the RejectionRate, or the value it drew for the
+// combination of all rates with RollCombined. The Roll of any rate for which no
+// value was drawn, such as a rate after the one that rejected with
+// RejectionCombinerSequential, is zero.
+type Decision struct {
+	Name       string
+	Usage      float32
+	Likelihood float32
+	Rate       float32
+	Roll       float32
 }
 
 func (self ErrRejection) Error() string {
+	prefix := "Rejected:"
+	if self.Path != "" {
+		prefix = fmt.Sprintf("Rejected: Path(%s)", self.Path)
+	}
 	if self.Rule != RuleProbabilistic {
-		return fmt.Sprintf("Rejected: Rule(%s) Class(%s)", self.Rule, self.Classification)
+		return fmt.Sprintf("%s Rule(%s) Class(%s)", prefix, self.Rule, self.Classification)
 	}
-	return fmt.Sprintf("Rejected: Rule(%s) Class(%s) %s(Usage(%3.1f%%),Likelihood(%3.1f%%),Rate(%3.1f%%))", self.Rule, self.Classification, self.Name, self.Usage*100, self.Likelihood*100, self.Rate*100)
+	return fmt.Sprintf("%s Rule(%s) Class(%s) %s(Usage(%3.1f%%),Likelihood(%3.1f%%),Rate(%3.1f%%))", prefix, self.Rule, self.Classification, self.Name, self.Usage*100, self.Likelihood*100, self.Rate*100)
 }
 
-type OptionShedder func(*Shedder)
+// Observer receives a notification for each decision made by a Shedder and
+// for each completed execution of an admitted Fn. Observers are intended for
+// collecting metrics, logs, or traces of load shedding behavior.
+//
+// Observers are called synchronously and in the order they were added to the
+// Shedder so implementations should avoid blocking.
+type Observer interface {
+	// Admit is called each time an invocation passes all rules and rejection
+	// rates. When the Shedder wraps the Fn, such as with Do, Admit is called
+	// only once the invocation has also passed any wrapper that may reject
+	// it, such as a CapacityQueue. The decisions contain the Decision of every
+	// RejectionRate of the Shedder, in the order they were added, that was
+	// used to admit the invocation. They are empty if the Shedder has no
+	// rejection rates.
+	Admit(ctx context.Context, decisions []Decision)
+	// Reject is called each time an invocation is rejected, either by a rule,
+	// a rejection rate, or a wrapper, and is given the same ErrRejection that
+	// is returned to the caller.
+	Reject(ctx context.Context, err ErrRejection)
+	// Complete is called each time an admitted Fn returns. The duration
+	// includes any time spent in the invocation monitoring wrappers and the
+	// error is the value returned to the caller.
+	Complete(ctx context.Context, duration time.Duration, err error)
+}
+
+// OptionShedder configures the policy of a Shedder. Options are given to
+// either NewShedder or Update.
+type OptionShedder func(*shedderPolicy)
 
 func OptionShedderRejectionRate(r RejectionRate) OptionShedder {
-	return func(s *Shedder) {
-		s.rejectionRates = append(s.rejectionRates, r)
+	return func(p *shedderPolicy) {
+		p.rejectionRates = append(p.rejectionRates, r)
 	}
 }
 
 func OptionShedderRule(r Rule) OptionShedder {
-	return func(s *Shedder) {
-		s.rules = append(s.rules, r)
+	return func(p *shedderPolicy) {
+		p.rules = append(p.rules, r)
 	}
 }
 
 func OptionShedderClassifier(c Classifier) OptionShedder {
-	return func(s *Shedder) {
-		s.classifier = c
+	return func(p *shedderPolicy) {
+		p.classifier = c
+	}
+}
+
+// OptionShedderObserver adds an Observer to the Shedder. This option may be
+// given multiple times to install multiple observers.
+func OptionShedderObserver(o Observer) OptionShedder {
+	return func(p *shedderPolicy) {
+		p.observers = append(p.observers, o)
+	}
+}
+
+// OptionShedderDryRun toggles dry-run mode for the Shedder. In dry-run mode
+// every rule and rejection rate is still evaluated and any resulting
+// ErrRejection, including those returned by wrappers such as a full
+// CapacityQueue, is reported to the installed observers with the DryRun field
+// set but the invocation is always admitted. Observers are then notified of
+// the admission as well. This is intended for evaluating the behavior of a
+// policy against real traffic before enforcing it.
+func OptionShedderDryRun(enabled bool) OptionShedder {
+	return func(p *shedderPolicy) {
+		p.dryRun = enabled
+	}
+}
+
+// OptionShedderShadow installs a shadow Shedder that is evaluated alongside
+// the enforcing Shedder. The shadow sees every invocation given to the
+// enforcing Shedder, its invocation monitoring wrappers are applied to every
+// admitted Fn, and its observers are notified as usual. The decisions of the
+// shadow never affect the enforcing Shedder.
+//
+// The invocation monitoring wrappers of a shadow are passive. A CapacityQueue
+// or CapacityQueueCoDel of a shadow tracks concurrency but never queues or
+// rejects and, if any other wrapper of a shadow rejects, the Fn is executed
+// regardless.
+//
+// The shadow is typically configured with OptionShedderDryRun and an Observer
+// so that a candidate policy can be compared with the active policy. This
+// option may be given multiple times to install multiple shadows.
+func OptionShedderShadow(shadow *Shedder) OptionShedder {
+	return func(p *shedderPolicy) {
+		p.shadows = append(p.shadows, shadow)
+	}
+}
+
+// OptionShedderRejectionCombiner sets the strategy used to combine multiple
+// rejection rates into a single decision. The default is
+// RejectionCombinerSequential.
+func OptionShedderRejectionCombiner(c RejectionCombiner) OptionShedder {
+	return func(p *shedderPolicy) {
+		p.combiner = c
+	}
+}
+
+// OptionShedderName sets the name used to identify the Shedder in the Path of
+// an ErrRejection.
+func OptionShedderName(name string) OptionShedder {
+	return func(p *shedderPolicy) {
+		p.name = name
+	}
+}
+
+// OptionShedderParent composes the Shedder as a child of another Shedder. This
+// may be used to build a tree of policies such as a process-wide Shedder with
+// children for each route or downstream dependency.
+//
+// A child checks the decision of its parent before making its own and a
+// rejection from the parent is returned by the child with the Path of the
+// parent. The parent observers are notified of the parent decision and the
+// child observers are notified of every rejection returned by the child. The
+// invocation monitoring wrappers of each level, including the parent, are
+// applied exactly once.
+//
+// Each level classifies the invocation with its own Classifier, if one is
+// installed, only after its parent has admitted it. The rules, rejection
+// rates, wrappers, and observers of each level see the classification of that
+// level and the Fn sees the classification of the child. A level without a
+// Classifier uses the classification of its parent. The observers of a parent
+// are notified of an admission only when the rules and rejection rates of the
+// child also admit the invocation. Rejections made by the wrappers of a child
+// are reported to the parent observers as the completion of the Fn.
+//
+// Parents must not form a cycle.
+func OptionShedderParent(parent *Shedder) OptionShedder {
+	return func(p *shedderPolicy) {
+		p.parent = parent
+	}
+}
+
+// OptionShedderProbe installs a Probe that admits a portion of invocations
+// that would otherwise be rejected by the rejection rates. This ensures that
+// capacities such as CapacityErrorRate and CapacityLatency continue to receive
+// data while all other load is shed so that recovery can be measured. Admitted
+// probes are marked in the context given to the Fn and may be identified
+// with ProbeFromContext. Rejections made by a Rule are never admitted as a
+// probe.
+func OptionShedderProbe(p Probe) OptionShedder {
+	return func(sp *shedderPolicy) {
+		sp.prober = p
 	}
 }
 
 func OptionShedderRandom(r func() float32) OptionShedder {
-	return func(s *Shedder) {
-		s.randFloat = r
+	return func(p *shedderPolicy) {
+		p.randFloat = r
 	}
 }
 
@@ -71,23 +241,37 @@ func OptionShedderRandom(r func() float32) OptionShedder {
 //
 // The primary usage of the Shedder is intended to be the Do method which
 // applies all load shedding rules and rejection rates.
+//
+// The policies of a Shedder may be replaced at runtime using the Update
+// method. Each invocation uses a consistent snapshot of the policies that
+// were active when it began.
 type Shedder struct {
-	randFloat      func() float32
-	rejectionRates []RejectionRate
-	rules          []Rule
-	classifier     Classifier
+	policy atomic.Pointer[shedderPolicy]
 }
 
 func NewShedder(options ...OptionShedder) *Shedder {
-	s := &Shedder{
-		randFloat: rand.Float32,
-	}
-	for _, opt := range options {
-		opt(s)
-	}
+	s := &Shedder{}
+	s.policy.Store(newShedderPolicy(options...))
 	return s
 }
 
+// Update atomically replaces the entire policy of the Shedder with one built
+// from the given options. Any option not given is reset to its default value.
+// The hot path of the Shedder does not acquire any locks as a result of
+// supporting updates.
+//
+// Invocations that are already in progress are not interrupted and continue to
+// use the invocation monitoring wrappers of the policy that admitted them.
+// This means that any Capacity that tracks in-flight work, such as
+// CapacityConcurrency, always observes the completion of work that it
+// observed starting. To carry the state of a Capacity across an update, give
+// the same Capacity instance to the new options. For example, a
+// CapacityConcurrency or CapacityErrorRate used to build a new RejectionRate
+// with a different curve retains its current counts and rolling window.
+func (self *Shedder) Update(options ...OptionShedder) {
+	self.policy.Store(newShedderPolicy(options...))
+}
+
 // Do optionally runs the function based on the current state of the load
 // shedding policy configured for the Shedder. In the event that the function
 // is not executed the Shedder will return an ErrRejection.
@@ -96,18 +280,12 @@ func NewShedder(options ...OptionShedder) *Shedder {
 // If a Classifier is provided then the current classification is added to the
 // context before any other action.
 func (self *Shedder) Do(ctx context.Context, fn Fn) error {
-	if self.classifier != nil {
-		ctx = ClassificationToContext(ctx, self.classifier.Classify(ctx))
-	}
-	if err := self.Select(ctx); err != nil {
+	l := self.snapshot()
+	ctx, err := l.decide(l.invocation(ctx), true)
+	if err != nil {
 		return err
 	}
-	for _, rate := range self.rejectionRates {
-		if w, ok := rate.(Wrapper); ok {
-			fn = w.Wrap(fn)
-		}
-	}
-	return fn(ctx)
+	return l.wrap(fn)(ctx)
 }
 
 // Select performs the decision making process for the load shedder and
@@ -118,31 +296,168 @@ func (self *Shedder) Do(ctx context.Context, fn Fn) error {
 // Note that the context given must be the same context that would otherwise be
 // given to Do. Also note that Select does not apply any Fn wrapping or
 // classification so any invocation monitoring, metrics management, and
-// classification must be performed externally.
+// classification must be performed externally. Any installed Observer is
+// notified of the decision but not of the completion of the action.
+//
+// Any parent Shedder is consulted and any shadow Shedder is evaluated before
+// the decision is made. A Shedder in dry-run mode returns nil unless its
+// parent rejects. Probes are admitted as usual but, because the context is not
+// returned, they cannot be identified with ProbeFromContext.
 func (self *Shedder) Select(ctx context.Context) error {
-	for _, r := range self.rules {
-		if r.Reject(ctx) {
-			return ErrRejection{
-				Rule:           r.Name(ctx),
-				Classification: ClassificationFromContext(ctx),
-			}
+	l := self.snapshot()
+	ctx, err := l.decide(l.invocation(ctx), false)
+	if err != nil {
+		return err
+	}
+	l.admitLineage(ctx, -1)
+	return nil
+}
+
+// SelectN performs the decision making process of Select for a batch of
+// items. Each item is described by its classification and the returned slice
+// contains the decision for the item at the same offset. Rules and rejection
+// rates are evaluated once for each distinct classification in the batch
+// rather than once for each item while each item still receives an independent
+// probabilistic decision. Any installed Observer is notified of the decision
+// for each item.
+//
+// Like Select, SelectN does not apply any Fn wrapping. The Classifier of the
+// Shedder is also not used because the classifications are given. Items
+// admitted as probes cannot be identified. Use DoN if probes must be
+// recognized.
+func (self *Shedder) SelectN(ctx context.Context, classes []Classification) []error {
+	l := self.snapshot()
+	items, results := l.decideN(batchItems(l.invocation(ctx), classes), false)
+	for offset, err := range results {
+		if err == nil {
+			l.admitLineage(items[offset], offset)
 		}
 	}
-	for _, r := range self.rejectionRates {
-		rate := r.Rate(ctx)
-		diceRoll := self.randFloat()
-		if diceRoll < rate {
-			return ErrRejection{
-				Rule:           RuleProbabilistic,
-				Classification: ClassificationFromContext(ctx),
-				Name:           r.Name(ctx),
-				Usage:          r.Usage(ctx),
-				Likelihood:     r.Likelihood(ctx),
-				Rate:           rate,
-			}
+	return results
+}
+
+// DoN combines SelectN with the invocation monitoring of Do for a batch of
+// items. The function is called once with the offsets of the admitted items
+// and is not called if no item is admitted. The function is wrapped as a
+// single invocation with a cost, as given to CostToContext, equal to the cost
+// of the context multiplied by the number of admitted items. This allows the
+// concurrency, landing rate, and error rate capacities to record the admitted
+// items in bulk. Other capacities, such as latency, record a single
+// measurement for the batch.
+//
+// The probes slice given to the function is parallel to the admitted offsets
+// and reports whether each item was admitted as a probe. Probes are reported
+// this way because the function receives a single context for the batch so
+// ProbeFromContext cannot identify them.
+//
+// The returned slice contains the decision for each item and the returned
+// error is the result of the function.
+func (self *Shedder) DoN(ctx context.Context, classes []Classification, fn func(ctx context.Context, admitted []int, probes []bool) error) ([]error, error) {
+	l := self.snapshot()
+	ctx = l.invocation(ctx)
+	items, decisions := l.decideN(batchItems(ctx, classes), true)
+	admitted := make([]int, 0, len(classes))
+	probes := make([]bool, 0, len(classes))
+	admittedItems := make([]context.Context, 0, len(classes))
+	for offset, err := range decisions {
+		if err == nil {
+			admitted = append(admitted, offset)
+			probes = append(probes, ProbeFromContext(items[offset]))
+			admittedItems = append(admittedItems, items[offset])
 		}
 	}
-	return nil
+	if len(admitted) < 1 {
+		return decisions, nil
+	}
+	if inv, ok := ctx.Value(invocationCtxKey).(*invocation); ok {
+		inv.batch(admittedItems, admitted)
+	}
+	ctx = CostToContext(ctx, CostFromContext(ctx)*len(admitted))
+	err := l.wrap(func(ctx context.Context) error {
+		return fn(ctx, admitted, probes)
+	})(ctx)
+	return decisions, err
+}
+
+// batchItems returns a context for each item of a batch with the item
+// classification.
+func batchItems(ctx context.Context, classes []Classification) []context.Context {
+	items := make([]context.Context, len(classes))
+	for offset, class := range classes {
+		items[offset] = ClassificationToContext(ctx, class)
+	}
+	return items
+}
+
+// Ticket represents work admitted by Acquire. The invocation monitoring of
+// the Shedder remains active until Release is called.
+type Ticket interface {
+	// Context returns the context of the admitted work. This includes the
+	// classification and any values added by invocation monitoring wrappers.
+	Context() context.Context
+	// Release ends the admitted work and records the given error, which may be
+	// nil, in any capacity that tracks results. Only the first call has any
+	// effect and it returns once all invocation monitoring has completed.
+	Release(err error)
+}
+
+// Acquire is an alternative to Do for work that does not fit within a single
+// function call, such as streaming responses, long-lived connections, or
+// worker leases. The same decision is made as for Do and, if the work is
+// admitted, all invocation monitoring wrappers are started. The returned
+// Ticket must be released when the work ends so that concurrency, latency,
+// and error rate accounting is completed.
+//
+// Each Ticket holds a goroutine until it is released so failing to release a
+// Ticket leaks both the goroutine and any capacity it reserved. Any rejection
+// made by a wrapper, such as a full admission queue, is returned as the
+// error.
+func (self *Shedder) Acquire(ctx context.Context) (Ticket, error) {
+	l := self.snapshot()
+	ctx, err := l.decide(l.invocation(ctx), true)
+	if err != nil {
+		return nil, err
+	}
+	t := &ticket{
+		started: make(chan context.Context, 1),
+		release: make(chan error, 1),
+		done:    make(chan struct{}),
+		once:    &sync.Once{},
+	}
+	result := make(chan error, 1)
+	fn := l.wrap(func(ctx context.Context) error {
+		t.started <- ctx
+		return <-t.release
+	})
+	go func() {
+		defer close(t.done)
+		result <- fn(ctx)
+	}()
+	select {
+	case t.ctx = <-t.started:
+		return t, nil
+	case err := <-result:
+		return nil, err
+	}
+}
+
+type ticket struct {
+	ctx     context.Context
+	started chan context.Context
+	release chan error
+	done    chan struct{}
+	once    *sync.Once
+}
+
+func (self *ticket) Context() context.Context {
+	return self.ctx
+}
+
+func (self *ticket) Release(err error) {
+	self.once.Do(func() {
+		self.release <- err
+		<-self.done
+	})
 }
 
 // WrapSelect returns a wrapped version of Fn that both applies any invocation
@@ -153,24 +468,799 @@ func (self *Shedder) Select(ctx context.Context) error {
 // the shedder should be through the Do method but this method is provided for
 // specialized cases where the input parameters for the Fn do not change with
 // each invocation. This allows Fn to be called repeatedly without needing to be
-// re-wrapped on each invocation.
+// re-wrapped on each invocation. The Fn is only re-wrapped after the Shedder
+// policy is updated.
 func (self *Shedder) WrapSelect(fn Fn) Fn {
-	for _, rate := range self.rejectionRates {
+	cache := &atomic.Pointer[wrappedFn]{}
+	return func(ctx context.Context) error {
+		l := self.snapshot()
+		wrapped := cache.Load()
+		if wrapped == nil || (wrapped.level != l && !wrapped.level.same(l)) {
+			wrapped = &wrappedFn{level: l, fn: l.wrap(fn)}
+			cache.Store(wrapped)
+		}
+		// The snapshot used to wrap the Fn is also used to make the decision
+		// so that both see the same policies.
+		ctx, err := wrapped.level.decide(wrapped.level.invocation(ctx), true)
+		if err != nil {
+			return err
+		}
+		return wrapped.fn(ctx)
+	}
+}
+
+// wrappedFn records the snapshot of the policies that was used to wrap an Fn.
+type wrappedFn struct {
+	level *level
+	fn    Fn
+}
+
+// snapshot returns the current policy of the Shedder along with the current
+// policy of every parent and shadow. Each invocation takes a single snapshot
+// and uses it for every decision, wrapper, and notification so that an Update
+// made while the invocation is in progress does not affect it. The snapshot is
+// cached by the policy and only rebuilt after a parent or shadow is updated.
+func (self *Shedder) snapshot() *level {
+	p := self.policy.Load()
+	l := p.snapshot.Load()
+	if l == nil || !l.current() {
+		l = newLevel(p)
+		p.snapshot.Store(l)
+	}
+	return l
+}
+
+// level is the policy of a single Shedder within a snapshot. The parent and
+// shadows contain the policies of the parent and shadow Shedders that were
+// current when the snapshot was taken. Each level of a snapshot has an index
+// that locates its state within an invocation.
+//
+// A snapshot is tracked if it has a parent, a shadow, an observer, or is in
+// dry-run mode. Only tracked snapshots record the progress of an invocation
+// through the wrappers of each level. An untracked snapshot applies the
+// wrappers of its rules and rejection rates directly.
+type level struct {
+	policy  *shedderPolicy
+	parent  *level
+	shadows []*level
+	root    *level
+	index   int
+	size    int
+	tracked bool
+}
+
+func newLevel(p *shedderPolicy) *level {
+	root := &level{policy: p}
+	root.root = root
+	root.size = 1
+	root.build()
+	root.tracked = root.parent != nil || len(root.shadows) > 0 || len(p.observers) > 0 || p.dryRun
+	return root
+}
+
+// build adds the current policy of every parent and shadow of the level to the
+// snapshot.
+func (self *level) build() {
+	if self.policy.parent != nil {
+		self.parent = self.root.add(self.policy.parent.policy.Load())
+	}
+	for _, shadow := range self.policy.shadows {
+		self.shadows = append(self.shadows, self.root.add(shadow.policy.Load()))
+	}
+}
+
+func (self *level) add(p *shedderPolicy) *level {
+	l := &level{policy: p, root: self, index: self.size, tracked: true}
+	self.size = self.size + 1
+	l.build()
+	return l
+}
+
+// current reports whether every parent and shadow of the snapshot still has
+// the policy that it had when the snapshot was taken.
+func (self *level) current() bool {
+	if self.parent != nil {
+		if self.parent.policy != self.policy.parent.policy.Load() || !self.parent.current() {
+			return false
+		}
+	}
+	for offset, shadow := range self.shadows {
+		if shadow.policy != self.policy.shadows[offset].policy.Load() || !shadow.current() {
+			return false
+		}
+	}
+	return true
+}
+
+// same reports whether the snapshots contain the same policy for every
+// parent and every shadow. Levels of the same policy always have the same
+// number of shadows and the same presence of a parent.
+func (self *level) same(other *level) bool {
+	if self.policy != other.policy {
+		return false
+	}
+	for offset, shadow := range self.shadows {
+		if !shadow.same(other.shadows[offset]) {
+			return false
+		}
+	}
+	return self.parent == nil || self.parent.same(other.parent)
+}
+
+// shedderPolicy is an immutable set of load shedding policies. The Shedder
+// holds a reference to exactly one policy at a time. Only the cached snapshot
+// of the policy changes after it is built.
+type shedderPolicy struct {
+	randFloat      func() float32
+	rejectionRates []RejectionRate
+	combiner       RejectionCombiner
+	rules          []Rule
+	classifier     Classifier
+	observers      []Observer
+	shadows        []*Shedder
+	dryRun         bool
+	name           string
+	parent         *Shedder
+	prober         Probe
+	snapshot       atomic.Pointer[level]
+}
+
+func newShedderPolicy(options ...OptionShedder) *shedderPolicy {
+	p := &shedderPolicy{
+		randFloat: rand.Float32,
+		combiner:  NewRejectionCombinerSequential(),
+	}
+	for _, opt := range options {
+		opt(p)
+	}
+	return p
+}
+
+// decide implements the Select method of the Shedder. The returned context is
+// marked if the invocation was admitted as a probe. Observers are notified of
+// rejections but not of admissions. If wrapped is set then each level
+// classifies the invocation once its parent has admitted it and admissions are
+// notified by the wrappers. Otherwise, admissions must be notified with
+// admitLineage.
+func (self *level) decide(ctx context.Context, wrapped bool) (context.Context, error) {
+	p := self.policy
+	for _, shadow := range self.shadows {
+		sctx, err := shadow.decide(ctx, wrapped)
+		switch {
+		case err == nil && !wrapped:
+			shadow.admitLineage(sctx, -1)
+		case err != nil && wrapped:
+			// The wrappers of the shadow are still applied but its observers
+			// must not see an admission for an invocation it rejected.
+			shadow.exclude(ctx, -1, 0)
+		}
+	}
+	if self.parent != nil {
+		var err error
+		ctx, err = self.parent.decide(ctx, wrapped)
+		if err != nil {
+			for _, o := range p.observers {
+				o.Reject(ctx, err.(ErrRejection))
+			}
+			return ctx, err
+		}
+	}
+	if wrapped {
+		ctx = self.classify(ctx)
+	}
+	ev := p.evaluate(ctx)
+	rejection, decisions := p.selectRejection(ctx, &ev)
+	ctx, rejection = p.probe(ctx, rejection)
+	if decisions != nil && self.tracked {
+		self.admission(ctx).record(-1, 0, decisions)
+	}
+	return ctx, self.report(ctx, rejection)
+}
+
+// decideN implements the SelectN method of the Shedder. Rules and rates are
+// evaluated once for each distinct classification. The context of each item is
+// returned with any probe marked. Admissions are notified in the same way as
+// decide.
+func (self *level) decideN(items []context.Context, wrapped bool) ([]context.Context, []error) {
+	p := self.policy
+	for _, shadow := range self.shadows {
+		sitems, results := shadow.decideN(items, wrapped)
+		for offset, err := range results {
+			switch {
+			case err == nil && !wrapped:
+				shadow.admitLineage(sitems[offset], offset)
+			case err != nil && wrapped:
+				shadow.exclude(items[offset], offset, len(items))
+			}
+		}
+	}
+	results := make([]error, len(items))
+	if self.parent != nil {
+		items, results = self.parent.decideN(items, wrapped)
+	} else {
+		items = append([]context.Context(nil), items...)
+	}
+	evaluations := make(map[Classification]*evaluation)
+	for offset, ictx := range items {
+		if results[offset] != nil {
+			for _, o := range p.observers {
+				o.Reject(ictx, results[offset].(ErrRejection))
+			}
+			continue
+		}
+		class := ClassificationFromContext(ictx)
+		ev, ok := evaluations[class]
+		if !ok {
+			e := p.evaluate(ictx)
+			ev = &e
+			evaluations[class] = ev
+		}
+		rejection, decisions := p.selectRejection(ictx, ev)
+		ictx, rejection = p.probe(ictx, rejection)
+		if decisions != nil && self.tracked {
+			self.admission(ictx).record(offset, len(items), decisions)
+		}
+		items[offset] = ictx
+		results[offset] = self.report(ictx, rejection)
+	}
+	return items, results
+}
+
+// probe admits a probabilistic rejection if the Probe allows it and marks the
+// context of the probe.
+func (self *shedderPolicy) probe(ctx context.Context, err *ErrRejection) (context.Context, *ErrRejection) {
+	if err == nil || err.Rule != RuleProbabilistic || self.prober == nil || self.dryRun {
+		return ctx, err
+	}
+	if !self.prober.Allow(ctx) {
+		return ctx, err
+	}
+	return ProbeToContext(ctx), nil
+}
+
+// report notifies observers of any rejection and returns the error that should
+// be given to the caller.
+func (self *level) report(ctx context.Context, err *ErrRejection) error {
+	if err == nil {
+		return nil
+	}
+	err.Path = self.path()
+	err.DryRun = self.policy.dryRun
+	for _, o := range self.policy.observers {
+		o.Reject(ctx, *err)
+	}
+	if !self.policy.dryRun {
+		return *err
+	}
+	return nil
+}
+
+// admitLineage notifies the observers of the level and of every parent of an
+// admission that is not followed by any wrappers. The observers of the root
+// are notified first. A non-negative offset identifies an item of a batch.
+func (self *level) admitLineage(ctx context.Context, offset int) {
+	if self.parent != nil {
+		self.parent.admitLineage(ctx, offset)
+	}
+	if len(self.policy.observers) < 1 {
+		return
+	}
+	decisions := self.admission(ctx).decisionsOf(offset)
+	for _, o := range self.policy.observers {
+		o.Admit(ctx, decisions)
+	}
+}
+
+// exclude marks the invocation as rejected by the level and every parent so
+// that their wrappers are applied without notifying observers. The parents are
+// included because they are only notified of an admission when the level also
+// admits the invocation. A non-negative offset marks only that item of a batch
+// of the given size.
+func (self *level) exclude(ctx context.Context, offset int, size int) {
+	for l := self; l != nil; l = l.parent {
+		a := l.admission(ctx)
+		if offset < 0 {
+			a.rejected = true
+			continue
+		}
+		if a.excluded == nil {
+			a.excluded = make([]bool, size)
+		}
+		a.excluded[offset] = true
+	}
+}
+
+// evaluation contains the state of all rules and rejection rates for a single
+// classification. Each rejection rate is evaluated once and the resulting
+// Decision is reused for every rejection described by the evaluation. The
+// details of each rate are only allocated once a Decision is needed.
+type evaluation struct {
+	rule    *ErrRejection
+	rates   []float32
+	details []rateDetail
+}
+
+// rateDetail records the Decision and retry advice of a single rejection rate
+// once they are known.
+type rateDetail struct {
+	decision Decision
+	complete bool
+	retry    time.Duration
+	advised  bool
+}
+
+func (self *shedderPolicy) evaluate(ctx context.Context) evaluation {
+	for _, r := range self.rules {
+		if r.Reject(ctx) {
+			return evaluation{
+				rule: &ErrRejection{
+					Rule:           r.Name(ctx),
+					Classification: ClassificationFromContext(ctx),
+					RetryAfter:     retryAfter(ctx, r),
+				},
+			}
+		}
+	}
+	if len(self.rejectionRates) < 1 {
+		return evaluation{}
+	}
+	ev := evaluation{
+		rates: make([]float32, len(self.rejectionRates)),
+	}
+	for offset, r := range self.rejectionRates {
+		e, ok := r.(Evaluator)
+		if !ok {
+			ev.rates[offset] = r.Rate(ctx)
+			continue
+		}
+		detail := ev.detail(offset)
+		detail.decision = e.Evaluate(ctx)
+		detail.complete = true
+		ev.rates[offset] = detail.decision.Rate
+	}
+	return ev
+}
+
+// detail returns the details of a rejection rate.
+func (self *evaluation) detail(offset int) *rateDetail {
+	if self.details == nil {
+		self.details = make([]rateDetail, len(self.rates))
+	}
+	return &self.details[offset]
+}
+
+// decision returns the Decision of a rejection rate, completing it if the
+// rate does not implement Evaluator.
+func (self *shedderPolicy) decision(ctx context.Context, ev *evaluation, offset int) Decision {
+	detail := ev.detail(offset)
+	if !detail.complete {
+		r := self.rejectionRates[offset]
+		detail.decision = Decision{
+			Name:       r.Name(ctx),
+			Usage:      r.Usage(ctx),
+			Likelihood: r.Likelihood(ctx),
+			Rate:       ev.rates[offset],
+		}
+		detail.complete = true
+	}
+	return detail.decision
+}
+
+// retryAfter returns the advice of a rejection rate, computing it only once.
+func (self *shedderPolicy) retryAfter(ctx context.Context, ev *evaluation, offset int) time.Duration {
+	detail := ev.detail(offset)
+	if !detail.advised {
+		detail.retry = retryAfter(ctx, self.rejectionRates[offset])
+		detail.advised = true
+	}
+	return detail.retry
+}
+
+// selectRejection applies the rules and rejection rates of the evaluation. The
+// Decision of every rejection rate, including the random values drawn for it,
+// is returned if the invocation is rejected or if the policy has observers
+// that must be given the decisions of an admission.
+func (self *shedderPolicy) selectRejection(ctx context.Context, ev *evaluation) (*ErrRejection, []Decision) {
+	if ev.rule != nil {
+		err := *ev.rule
+		return &err, nil
+	}
+	if len(ev.rates) < 1 {
+		return nil, nil
+	}
+	d := &draw{random: self.randFloat}
+	if len(ev.rates) <= len(d.buffer) {
+		d.rolls = d.buffer[:len(ev.rates)]
+	} else {
+		d.rolls = make([]float32, len(ev.rates))
+	}
+	reject, rate, primary := self.combiner.Combine(ctx, ev.rates, d.roll)
+	if !reject && len(self.observers) < 1 {
+		return nil, nil
+	}
+	decisions := make([]Decision, len(ev.rates))
+	for offset := range ev.rates {
+		decisions[offset] = self.decision(ctx, ev, offset)
+		decisions[offset].Roll = d.rolls[offset]
+	}
+	if !reject {
+		return nil, decisions
+	}
+	err := &ErrRejection{
+		Rule:           RuleProbabilistic,
+		Classification: ClassificationFromContext(ctx),
+		Rate:           rate,
+		Contributions:  make([]Decision, 0, len(ev.rates)),
+	}
+	err.Roll = d.last
+	for offset, decision := range decisions {
+		if decision.Rate <= 0 && offset != primary {
+			continue
+		}
+		err.Contributions = append(err.Contributions, decision)
+		if offset == primary {
+			err.Name = decision.Name
+			err.Usage = decision.Usage
+			err.Likelihood = decision.Likelihood
+			err.RetryAfter = self.retryAfter(ctx, ev, offset)
+		}
+	}
+	return err, decisions
+}
+
+// draw records the random values given to a RejectionCombiner by the offset of
+// the rate they were drawn for. The buffer holds the values of most policies
+// without another allocation.
+type draw struct {
+	random func() float32
+	rolls  []float32
+	last   float32
+	buffer [4]float32
+}
+
+func (self *draw) roll(offset int) float32 {
+	r := self.random()
+	self.last = r
+	switch {
+	case offset == RollCombined:
+		for index := range self.rolls {
+			self.rolls[index] = r
+		}
+	case offset >= 0 && offset < len(self.rolls):
+		self.rolls[offset] = r
+	}
+	return r
+}
+
+// classify adds the classification to the context if a Classifier is set and
+// records it so that the wrappers of the level see the same classification
+// even if a child classifies the invocation differently.
+func (self *level) classify(ctx context.Context) context.Context {
+	if self.policy.classifier == nil {
+		return ctx
+	}
+	class := self.policy.classifier.Classify(ctx)
+	if self.tracked {
+		a := self.admission(ctx)
+		a.class = class
+		a.classified = true
+	}
+	return ClassificationToContext(ctx, class)
+}
+
+// path returns the names of the parents and the level joined by a slash.
+func (self *level) path() string {
+	name := self.policy.name
+	if self.parent == nil {
+		return name
+	}
+	p := self.parent.path()
+	switch {
+	case p == "":
+		return name
+	case name == "":
+		return p
+	}
+	return p + "/" + name
+}
+
+// wrap applies all invocation monitoring wrappers, including those of any
+// rule, shadow, or parent, to the Fn and reports the admission, rejection, and
+// completion of the Fn to the observers of each level.
+func (self *level) wrap(fn Fn) Fn {
+	var admitted Fn
+	if self.tracked {
+		admitted = self.admitted(fn)
+		fn = admitted
+	}
+	for _, rate := range self.policy.rejectionRates {
 		if w, ok := rate.(Wrapper); ok {
 			fn = w.Wrap(fn)
 		}
 	}
-	return func(ctx context.Context) error {
-		if self.classifier != nil {
-			ctx = ClassificationToContext(ctx, self.classifier.Classify(ctx))
+	for _, rule := range self.policy.rules {
+		if w, ok := rule.(Wrapper); ok {
+			fn = w.Wrap(fn)
 		}
-		if err := self.Select(ctx); err != nil {
+	}
+	if !self.tracked {
+		return fn
+	}
+	for _, shadow := range self.shadows {
+		fn = shadow.shadow(fn)
+	}
+	fn = self.observe(fn, admitted)
+	if self.parent != nil {
+		fn = self.relay(self.parent.wrap(fn))
+	}
+	return fn
+}
+
+// shadow applies the invocation monitoring wrappers of a shadow level to the
+// Fn such that they cannot affect the invocation. The wrappers are marked as
+// passive in the context so that those which would otherwise queue the
+// invocation, such as CapacityQueue, only record it. If a wrapper rejects the
+// invocation anyway then the Fn is called directly.
+func (self *level) shadow(fn Fn) Fn {
+	wrapped := self.wrap(func(ctx context.Context) error {
+		p := ctx.Value(passiveCtxKey).(*passive)
+		p.reached = true
+		ctx = ClassificationToContext(ctx, p.class)
+		return fn(context.WithValue(ctx, passiveCtxKey, (*passive)(nil)))
+	})
+	return func(ctx context.Context) error {
+		p := &passive{class: ClassificationFromContext(ctx)}
+		err := wrapped(context.WithValue(ctx, passiveCtxKey, p))
+		if p.reached {
 			return err
 		}
 		return fn(ctx)
 	}
 }
 
+// observe reports any rejection made by the wrappers of the level and the
+// completion of the Fn to all observers. A wrapper rejection is admitted
+// anyway, by calling the admitted Fn directly, when in dry-run mode.
+func (self *level) observe(fn Fn, admitted Fn) Fn {
+	return func(ctx context.Context) error {
+		a := self.admission(ctx)
+		if a.classified {
+			ctx = ClassificationToContext(ctx, a.class)
+		}
+		if a.rejected {
+			return fn(ctx)
+		}
+		a.entered = true
+		start := time.Now()
+		err := fn(ctx)
+		if !a.admitted {
+			rejection, ok := err.(ErrRejection)
+			if !ok {
+				return err
+			}
+			rejection.Path = self.path()
+			rejection.DryRun = self.policy.dryRun
+			self.notify(ctx, func(ctx context.Context, _ []Decision, o Observer) {
+				o.Reject(ctx, rejection)
+			})
+			if !self.policy.dryRun {
+				return rejection
+			}
+			err = admitted(ctx)
+		}
+		d := time.Since(start)
+		for _, o := range self.policy.observers {
+			o.Complete(ctx, d, err)
+		}
+		return err
+	}
+}
+
+// admitted notifies the observers of an admission once all wrappers of the
+// policy have admitted the invocation.
+func (self *level) admitted(fn Fn) Fn {
+	return func(ctx context.Context) error {
+		a := self.admission(ctx)
+		a.admitted = true
+		if !a.rejected {
+			self.notify(ctx, func(ctx context.Context, decisions []Decision, o Observer) {
+				o.Admit(ctx, decisions)
+			})
+		}
+		return fn(ctx)
+	}
+}
+
+// relay reports any rejection made by the wrappers of a parent to the
+// observers of the policy.
+func (self *level) relay(fn Fn) Fn {
+	return func(ctx context.Context) error {
+		a := self.admission(ctx)
+		err := fn(ctx)
+		if rejection, ok := err.(ErrRejection); ok && !a.entered && !a.rejected {
+			self.notify(ctx, func(ctx context.Context, _ []Decision, o Observer) {
+				o.Reject(ctx, rejection)
+			})
+		}
+		return err
+	}
+}
+
+// admission returns the state of the current invocation for the level.
+func (self *level) admission(ctx context.Context) *admission {
+	inv, ok := ctx.Value(invocationCtxKey).(*invocation)
+	if !ok || inv.root != self.root {
+		return &admission{}
+	}
+	return &inv.levels[self.index]
+}
+
+// notify calls the function for each observer and each invocation along with
+// the decisions recorded by the level for the invocation. A batch given to DoN
+// notifies observers once for each admitted item that the level did not
+// reject.
+func (self *level) notify(ctx context.Context, fn func(context.Context, []Decision, Observer)) {
+	if len(self.policy.observers) < 1 {
+		return
+	}
+	a := self.admission(ctx)
+	inv, ok := ctx.Value(invocationCtxKey).(*invocation)
+	if !ok || inv.items == nil {
+		for _, o := range self.policy.observers {
+			fn(ctx, a.decisions, o)
+		}
+		return
+	}
+	for offset, item := range inv.items {
+		index := inv.offsets[offset]
+		if a.excluded != nil && a.excluded[index] {
+			continue
+		}
+		for _, o := range self.policy.observers {
+			fn(item, a.decisionsOf(index), o)
+		}
+	}
+}
+
+// evaluateProbability returns the Decision of the FailureProbability, using
+// Evaluator if it is implemented.
+func evaluateProbability(ctx context.Context, p FailureProbability) Decision {
+	if e, ok := p.(Evaluator); ok {
+		return e.Evaluate(ctx)
+	}
+	return Decision{
+		Name:       p.Name(ctx),
+		Usage:      p.Usage(ctx),
+		Likelihood: p.Likelihood(ctx),
+	}
+}
+
+// evaluateRate returns the Decision of the RejectionRate, using Evaluator if it
+// is implemented.
+func evaluateRate(ctx context.Context, r RejectionRate) Decision {
+	if e, ok := r.(Evaluator); ok {
+		return e.Evaluate(ctx)
+	}
+	d := evaluateProbability(ctx, r)
+	d.Rate = r.Rate(ctx)
+	return d
+}
+
+// retryAfter returns the advice of the value if it implements RetryAdvisor.
+func retryAfter(ctx context.Context, v interface{}) time.Duration {
+	if a, ok := v.(RetryAdvisor); ok {
+		return a.RetryAfter(ctx)
+	}
+	return 0
+}
+
+type invocationCtxKeyType struct{}
+
+var invocationCtxKey = invocationCtxKeyType{} //nolint: gochecknoglobals
+
+// invocation records the progress of a single call to Do, DoN, Acquire, or a
+// Fn returned by WrapSelect through the wrappers of each policy. The items are
+// the contexts of the admitted items of a batch given to DoN and the offsets
+// are the positions of those items within the batch.
+type invocation struct {
+	root    *level
+	levels  []admission
+	items   []context.Context
+	offsets []int
+}
+
+// invocation adds the state of a new invocation of the snapshot to the context
+// if the snapshot is tracked.
+func (self *level) invocation(ctx context.Context) context.Context {
+	if !self.tracked {
+		return ctx
+	}
+	return context.WithValue(ctx, invocationCtxKey, &invocation{
+		root:   self,
+		levels: make([]admission, self.size),
+	})
+}
+
+// batch records the admitted items of a batch. A level that rejected every
+// admitted item is marked as rejected so that, as with a single invocation,
+// its observers see neither an admission nor a completion.
+func (self *invocation) batch(items []context.Context, offsets []int) {
+	self.items = items
+	self.offsets = offsets
+	for index := range self.levels {
+		a := &self.levels[index]
+		if a.excluded == nil {
+			continue
+		}
+		rejected := true
+		for _, offset := range offsets {
+			rejected = rejected && a.excluded[offset]
+		}
+		a.rejected = rejected
+	}
+}
+
+// admission records how far an invocation progressed through the wrappers of
+// a policy. The rejected field is set for a shadow, or a parent of a shadow,
+// that rejected the invocation so that its wrappers are applied without
+// notifying observers. The excluded field marks the items of a batch that were
+// rejected in the same way. The decisions made by the policy are kept for the
+// observers, either for the invocation or for each item of a batch.
+type admission struct {
+	entered    bool
+	admitted   bool
+	rejected   bool
+	classified bool
+	class      Classification
+	excluded   []bool
+	decisions  []Decision
+	items      [][]Decision
+}
+
+// record stores the decisions made for the invocation or, with a non-negative
+// offset, for that item of a batch of the given size.
+func (self *admission) record(offset int, size int, decisions []Decision) {
+	if offset < 0 {
+		self.decisions = decisions
+		return
+	}
+	if self.items == nil {
+		self.items = make([][]Decision, size)
+	}
+	self.items[offset] = decisions
+}
+
+// decisionsOf returns the decisions recorded for the invocation or, with a
+// non-negative offset, for that item of a batch.
+func (self *admission) decisionsOf(offset int) []Decision {
+	if offset < 0 {
+		return self.decisions
+	}
+	if self.items == nil {
+		return nil
+	}
+	return self.items[offset]
+}
+
+type passiveCtxKeyType struct{}
+
+var passiveCtxKey = passiveCtxKeyType{} //nolint: gochecknoglobals
+
+// passive is set in the context while the wrappers of a shadow are applied and
+// records whether the wrappers called the Fn. The classification of the
+// enforcing Shedder is restored before the Fn is called.
+type passive struct {
+	reached bool
+	class   Classification
+}
+
+// passiveFromContext reports whether the current wrappers belong to a shadow
+// and must not reject or delay the invocation.
+func passiveFromContext(ctx context.Context) bool {
+	p, _ := ctx.Value(passiveCtxKey).(*passive)
+	return p != nil
+}
+
 // RuleProbabilistic is the name of the rejection rule that covers all cases of
 // using a rejection rate rather than a deterministic rule.
 const RuleProbabilistic string = "PROBABILISTIC"
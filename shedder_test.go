@@ -8,6 +8,7 @@ import (
 	"context"
 	"errors"
 	"testing"
+	"time"
 )
 
 var shedErr error
@@ -110,32 +111,1130 @@ func TestShedderDoAllRejection(t *testing.T) {
 	}
 }
 
+func TestShedderDoRejectionRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	cap := NewCapacityErrorRate(
+		OptionErrorRateWindowBuckets(10),
+		OptionErrorRateBucketDuration(time.Millisecond),
+	)
+	shed := NewShedder(
+		OptionShedderRejectionRate(NewRejectionRateCurveIdentity(&FailureProbabilityCurve{
+			Capacity: cap,
+			Curve:    CurveFN(func(ctx context.Context, value float32) float32 { return 1 }),
+		})),
+	)
+	err := shed.Do(context.Background(), func(ctx context.Context) error { return nil })
+	var e ErrRejection
+	if !errors.As(err, &e) {
+		t.Fatalf("expected ErrRejection but got %v", err)
+	}
+	if e.RetryAfter != 10*time.Millisecond {
+		t.Fatalf("expected retry after %s but got %s", 10*time.Millisecond, e.RetryAfter)
+	}
+}
+
 func TestShedderDoAllRejectionUsingRule(t *testing.T) {
 	t.Parallel()
 
-	cls := Classification("TEST")
-	shed := NewShedder(
+	cls := Classification("TEST")
+	shed := NewShedder(
+		OptionShedderRule(&staticRule{reject: true}),
+		OptionShedderClassifier(ClassifierFN(func(ctx context.Context) Classification { return cls })),
+	)
+
+	ctx := context.Background()
+	fn := func(ctx context.Context) error {
+		return nil
+	}
+	err := shed.Do(ctx, fn)
+	if err == nil {
+		t.Fatal("expected ErrRejection but got nil")
+	}
+	var e ErrRejection
+	if !errors.As(err, &e) {
+		t.Fatalf("expected ErrRejection but got %s", err)
+	}
+	if e.Rule != nameStatic {
+		t.Fatalf("expected rule %s but got %s", nameStatic, e.Rule)
+	}
+	if e.Classification != cls {
+		t.Fatalf("expected classification %s but got %s", cls, e.Classification)
+	}
+}
+
+func TestShedderDoObserverAdmit(t *testing.T) {
+	t.Parallel()
+
+	obs := &recordingObserver{}
+	shed := NewShedder(
+		OptionShedderRejectionRate(&staticCountingRate{value: 0.0}),
+		OptionShedderObserver(obs),
+	)
+	ctx := context.Background()
+	expected := errors.New("TEST")
+	fn := func(ctx context.Context) error {
+		return expected
+	}
+
+	err := shed.Do(ctx, fn)
+	if err != expected {
+		t.Fatalf("expected %s but got %s", expected, err)
+	}
+	if obs.admits != 1 {
+		t.Fatalf("expected %d admits but got %d", 1, obs.admits)
+	}
+	if len(obs.rejects) != 0 {
+		t.Fatalf("expected %d rejects but got %d", 0, len(obs.rejects))
+	}
+	if len(obs.completions) != 1 {
+		t.Fatalf("expected %d completions but got %d", 1, len(obs.completions))
+	}
+	if obs.completions[0] != expected {
+		t.Fatalf("expected completion error %s but got %s", expected, obs.completions[0])
+	}
+}
+
+func TestShedderDoObserverReject(t *testing.T) {
+	t.Parallel()
+
+	cls := Classification("TEST")
+	obs := &recordingObserver{}
+	shed := NewShedder(
+		OptionShedderRule(&staticRule{reject: true}),
+		OptionShedderClassifier(ClassifierFN(func(ctx context.Context) Classification { return cls })),
+		OptionShedderObserver(obs),
+	)
+	ctx := context.Background()
+	fn := func(ctx context.Context) error {
+		return nil
+	}
+
+	err := shed.Do(ctx, fn)
+	if err == nil {
+		t.Fatal("expected ErrRejection but got nil")
+	}
+	if obs.admits != 0 {
+		t.Fatalf("expected %d admits but got %d", 0, obs.admits)
+	}
+	if len(obs.rejects) != 1 {
+		t.Fatalf("expected %d rejects but got %d", 1, len(obs.rejects))
+	}
+	if obs.rejects[0].Rule != nameStatic {
+		t.Fatalf("expected rule %s but got %s", nameStatic, obs.rejects[0].Rule)
+	}
+	if obs.rejects[0].Classification != cls {
+		t.Fatalf("expected classification %s but got %s", cls, obs.rejects[0].Classification)
+	}
+	if len(obs.completions) != 0 {
+		t.Fatalf("expected %d completions but got %d", 0, len(obs.completions))
+	}
+}
+
+func TestShedderWrapSelectObserver(t *testing.T) {
+	t.Parallel()
+
+	obs := &recordingObserver{}
+	shed := NewShedder(
+		OptionShedderRejectionRate(&staticCountingRate{value: 0.0}),
+		OptionShedderObserver(obs),
+	)
+	ctx := context.Background()
+	fn := shed.WrapSelect(func(ctx context.Context) error {
+		return nil
+	})
+
+	for x := 0; x < 3; x = x + 1 {
+		if err := fn(ctx); err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+	}
+	if obs.admits != 3 {
+		t.Fatalf("expected %d admits but got %d", 3, obs.admits)
+	}
+	if len(obs.completions) != 3 {
+		t.Fatalf("expected %d completions but got %d", 3, len(obs.completions))
+	}
+}
+
+func TestShedderDoDryRun(t *testing.T) {
+	t.Parallel()
+
+	obs := &recordingObserver{}
+	shed := NewShedder(
+		OptionShedderRejectionRate(&staticCountingRate{value: 1.0}),
+		OptionShedderDryRun(true),
+		OptionShedderObserver(obs),
+	)
+	ctx := context.Background()
+	called := false
+	fn := func(ctx context.Context) error {
+		called = true
+		return nil
+	}
+
+	err := shed.Do(ctx, fn)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if !called {
+		t.Fatal("dry-run shedder did not execute the function")
+	}
+	if len(obs.rejects) != 1 {
+		t.Fatalf("expected %d rejects but got %d", 1, len(obs.rejects))
+	}
+	if !obs.rejects[0].DryRun {
+		t.Fatal("expected the rejection to be marked as dry-run")
+	}
+	if obs.rejects[0].Rule != RuleProbabilistic {
+		t.Fatalf("expected rule %s but got %s", RuleProbabilistic, obs.rejects[0].Rule)
+	}
+	if obs.admits != 1 {
+		t.Fatalf("expected %d admits but got %d", 1, obs.admits)
+	}
+}
+
+func TestShedderDoObserverWrapperRejection(t *testing.T) {
+	t.Parallel()
+
+	obs := &recordingObserver{}
+	shed := NewShedder(
+		OptionShedderRule(&wrapperRule{}),
+		OptionShedderObserver(obs),
+		OptionShedderName("api"),
+	)
+	ctx := context.Background()
+	fn := func(ctx context.Context) error {
+		return nil
+	}
+
+	err := shed.Do(ctx, fn)
+	var e ErrRejection
+	if !errors.As(err, &e) {
+		t.Fatalf("expected ErrRejection but got %v", err)
+	}
+	if e.Path != "api" {
+		t.Fatalf("expected path %q but got %q", "api", e.Path)
+	}
+	if obs.admits != 0 {
+		t.Fatalf("expected %d admits but got %d", 0, obs.admits)
+	}
+	if len(obs.rejects) != 1 {
+		t.Fatalf("expected %d rejects but got %d", 1, len(obs.rejects))
+	}
+	if len(obs.completions) != 0 {
+		t.Fatalf("expected %d completions but got %d", 0, len(obs.completions))
+	}
+}
+
+func TestShedderDoObserverQueueFull(t *testing.T) {
+	t.Parallel()
+
+	obs := &recordingObserver{}
+	q := NewCapacityQueueCoDel(NewCapacityConcurrency(1), 0, time.Hour, time.Hour)
+	shed := NewShedder(
+		OptionShedderRule(q),
+		OptionShedderObserver(obs),
+	)
+	ctx := context.Background()
+
+	running := make(chan struct{})
+	block := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- shed.Do(ctx, func(ctx context.Context) error {
+			close(running)
+			<-block
+			return nil
+		})
+	}()
+	<-running
+	err := shed.Do(ctx, func(ctx context.Context) error {
+		return nil
+	})
+	var e ErrRejection
+	if !errors.As(err, &e) || e.Rule != RuleQueueFull {
+		t.Fatalf("expected %s but got %v", RuleQueueFull, err)
+	}
+	if obs.admits != 1 {
+		t.Fatalf("expected %d admits but got %d", 1, obs.admits)
+	}
+	if len(obs.rejects) != 1 || obs.rejects[0].Rule != RuleQueueFull {
+		t.Fatalf("expected a %s reject but got %v", RuleQueueFull, obs.rejects)
+	}
+	close(block)
+	if err := <-done; err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if len(obs.completions) != 1 {
+		t.Fatalf("expected %d completions but got %d", 1, len(obs.completions))
+	}
+}
+
+func TestShedderDoDryRunWrapperRejection(t *testing.T) {
+	t.Parallel()
+
+	obs := &recordingObserver{}
+	shed := NewShedder(
+		OptionShedderRule(&wrapperRule{}),
+		OptionShedderDryRun(true),
+		OptionShedderObserver(obs),
+	)
+	ctx := context.Background()
+	calls := 0
+	fn := func(ctx context.Context) error {
+		calls = calls + 1
+		return nil
+	}
+
+	err := shed.Do(ctx, fn)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected %d calls but got %d", 1, calls)
+	}
+	if len(obs.rejects) != 1 || !obs.rejects[0].DryRun {
+		t.Fatalf("expected a dry-run reject but got %v", obs.rejects)
+	}
+	if obs.admits != 1 {
+		t.Fatalf("expected %d admits but got %d", 1, obs.admits)
+	}
+	if len(obs.completions) != 1 {
+		t.Fatalf("expected %d completions but got %d", 1, len(obs.completions))
+	}
+}
+
+func TestShedderDoShadow(t *testing.T) {
+	t.Parallel()
+
+	obs := &recordingObserver{}
+	shadowRate := &staticCountingRate{value: 1.0}
+	shadow := NewShedder(
+		OptionShedderRejectionRate(shadowRate),
+		OptionShedderDryRun(true),
+		OptionShedderObserver(obs),
+	)
+	shed := NewShedder(
+		OptionShedderRejectionRate(&staticCountingRate{value: 0.0}),
+		OptionShedderShadow(shadow),
+	)
+	ctx := context.Background()
+	fn := func(ctx context.Context) error {
+		return nil
+	}
+
+	err := shed.Do(ctx, fn)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if len(obs.rejects) != 1 {
+		t.Fatalf("expected %d shadow rejects but got %d", 1, len(obs.rejects))
+	}
+	if len(obs.completions) != 1 {
+		t.Fatalf("expected %d shadow completions but got %d", 1, len(obs.completions))
+	}
+	if shadowRate.count != 1 {
+		t.Fatalf("expected the shadow wrapper to be applied once but got %d", shadowRate.count)
+	}
+}
+
+func TestShedderDoShadowDoesNotEnforce(t *testing.T) {
+	t.Parallel()
+
+	shadow := NewShedder(
+		OptionShedderRule(&staticRule{reject: true}),
+	)
+	shed := NewShedder(
+		OptionShedderShadow(shadow),
+	)
+	ctx := context.Background()
+	fn := func(ctx context.Context) error {
+		return nil
+	}
+
+	err := shed.Do(ctx, fn)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+}
+
+func TestShedderDoShadowWrapperRejection(t *testing.T) {
+	t.Parallel()
+
+	shadow := NewShedder(
+		OptionShedderRule(&wrapperRule{}),
+		OptionShedderDryRun(true),
+	)
+	shed := NewShedder(
+		OptionShedderShadow(shadow),
+	)
+	ctx := context.Background()
+	calls := 0
+	fn := func(ctx context.Context) error {
+		calls = calls + 1
+		return nil
+	}
+
+	err := shed.Do(ctx, fn)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected %d calls but got %d", 1, calls)
+	}
+}
+
+func TestShedderDoShadowQueue(t *testing.T) {
+	t.Parallel()
+
+	conc := NewCapacityConcurrency(1)
+	q := NewCapacityQueueCoDel(conc, 0, time.Hour, time.Hour)
+	shadow := NewShedder(
+		OptionShedderRule(q),
+		OptionShedderDryRun(true),
+	)
+	shed := NewShedder(
+		OptionShedderShadow(shadow),
+	)
+	ctx := context.Background()
+
+	running := make(chan struct{})
+	block := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- shed.Do(ctx, func(ctx context.Context) error {
+			close(running)
+			<-block
+			return nil
+		})
+	}()
+	<-running
+	var usage float32
+	err := shed.Do(ctx, func(ctx context.Context) error {
+		usage = conc.Usage(ctx)
+		return nil
+	})
+	close(block)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if usage != 2 {
+		t.Fatalf("expected shadow concurrency usage %f but got %f", 2.0, usage)
+	}
+}
+
+func TestShedderDoShadowParentRejects(t *testing.T) {
+	t.Parallel()
+
+	obs := &recordingObserver{}
+	shadow := NewShedder(
+		OptionShedderRejectionRate(&staticCountingRate{value: 1.0}),
+		OptionShedderDryRun(true),
+		OptionShedderObserver(obs),
+	)
+	parent := NewShedder(
+		OptionShedderRule(&staticRule{reject: true}),
+	)
+	shed := NewShedder(
+		OptionShedderParent(parent),
+		OptionShedderShadow(shadow),
+	)
+	ctx := context.Background()
+	fn := func(ctx context.Context) error {
+		return nil
+	}
+
+	err := shed.Do(ctx, fn)
+	if err == nil {
+		t.Fatal("expected a rejection from the parent")
+	}
+	if len(obs.rejects) != 1 {
+		t.Fatalf("expected %d shadow rejects but got %d", 1, len(obs.rejects))
+	}
+}
+
+func TestShedderUpdate(t *testing.T) {
+	t.Parallel()
+
+	shed := NewShedder(
+		OptionShedderRule(&staticRule{reject: true}),
+	)
+	ctx := context.Background()
+	fn := func(ctx context.Context) error {
+		return nil
+	}
+
+	if err := shed.Do(ctx, fn); err == nil {
+		t.Fatal("expected ErrRejection but got nil")
+	}
+	shed.Update(
+		OptionShedderRule(&staticRule{reject: false}),
+	)
+	if err := shed.Do(ctx, fn); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+}
+
+func TestShedderUpdateInFlight(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	conc := NewCapacityConcurrency(10)
+	shed := NewShedder(
+		OptionShedderRejectionRate(NewRejectionRateCurveLinear(NewFailureProbabilityCurveIdentity(conc), .5, 1, 1)),
+	)
+	running := make(chan struct{})
+	block := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- shed.Do(ctx, func(ctx context.Context) error {
+			close(running)
+			<-block
+			return nil
+		})
+	}()
+	<-running
+
+	shed.Update(
+		OptionShedderRejectionRate(NewRejectionRateCurveLinear(NewFailureProbabilityCurveIdentity(conc), .8, 1, 1)),
+	)
+	var usage float32
+	err := shed.Do(ctx, func(ctx context.Context) error {
+		usage = conc.Usage(ctx)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if usage != .2 {
+		t.Fatalf("expected usage %f but got %f", .2, usage)
+	}
+
+	close(block)
+	if err := <-done; err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if conc.Usage(ctx) != 0 {
+		t.Fatalf("expected usage %f but got %f", 0.0, conc.Usage(ctx))
+	}
+}
+
+func TestShedderWrapSelectUpdate(t *testing.T) {
+	t.Parallel()
+
+	first := &staticCountingRate{value: 0}
+	second := &staticCountingRate{value: 0}
+	shed := NewShedder(
+		OptionShedderRejectionRate(first),
+	)
+	ctx := context.Background()
+	fn := shed.WrapSelect(func(ctx context.Context) error {
+		return nil
+	})
+
+	_ = fn(ctx)
+	shed.Update(
+		OptionShedderRejectionRate(second),
+	)
+	_ = fn(ctx)
+	_ = fn(ctx)
+	if first.count != 1 {
+		t.Fatalf("expected %d calls to the first wrapper but got %d", 1, first.count)
+	}
+	if second.count != 2 {
+		t.Fatalf("expected %d calls to the second wrapper but got %d", 2, second.count)
+	}
+}
+
+func TestShedderParentRejection(t *testing.T) {
+	t.Parallel()
+
+	rule := &staticRule{reject: true}
+	parent := NewShedder(
+		OptionShedderName("global"),
+		OptionShedderRule(rule),
+	)
+	obs := &recordingObserver{}
+	child := NewShedder(
+		OptionShedderName("api"),
+		OptionShedderParent(parent),
+		OptionShedderObserver(obs),
+	)
+	ctx := context.Background()
+	called := false
+	err := child.Do(ctx, func(ctx context.Context) error {
+		called = true
+		return nil
+	})
+	var e ErrRejection
+	if !errors.As(err, &e) {
+		t.Fatalf("expected ErrRejection but got %v", err)
+	}
+	if called {
+		t.Fatal("expected the function to not be called")
+	}
+	if e.Path != "global" {
+		t.Fatalf("expected path %s but got %s", "global", e.Path)
+	}
+	if len(obs.rejects) != 1 {
+		t.Fatalf("expected %d child rejections but got %d", 1, len(obs.rejects))
+	}
+
+	rule.reject = false
+	if err := child.Do(ctx, func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+}
+
+func TestShedderParentWrapperRejection(t *testing.T) {
+	t.Parallel()
+
+	parentObs := &recordingObserver{}
+	childObs := &recordingObserver{}
+	parent := NewShedder(
+		OptionShedderRule(&wrapperRule{}),
+		OptionShedderObserver(parentObs),
+		OptionShedderName("global"),
+	)
+	child := NewShedder(
+		OptionShedderParent(parent),
+		OptionShedderObserver(childObs),
+		OptionShedderName("api"),
+	)
+	ctx := context.Background()
+	fn := func(ctx context.Context) error {
+		return nil
+	}
+
+	err := child.Do(ctx, fn)
+	var e ErrRejection
+	if !errors.As(err, &e) {
+		t.Fatalf("expected ErrRejection but got %v", err)
+	}
+	if e.Path != "global" {
+		t.Fatalf("expected path %q but got %q", "global", e.Path)
+	}
+	for _, obs := range []*recordingObserver{parentObs, childObs} {
+		if obs.admits != 0 {
+			t.Fatalf("expected %d admits but got %d", 0, obs.admits)
+		}
+		if len(obs.rejects) != 1 || obs.rejects[0].Path != "global" {
+			t.Fatalf("expected one reject from %q but got %v", "global", obs.rejects)
+		}
+	}
+}
+
+func TestShedderParentClassification(t *testing.T) {
+	t.Parallel()
+
+	parentRate := &classRate{rates: map[Classification]float32{"PARENT": 0, "CHILD": 1}}
+	childRate := &classRate{rates: map[Classification]float32{"PARENT": 1, "CHILD": 0}}
+	parent := NewShedder(
+		OptionShedderRejectionRate(parentRate),
+		OptionShedderClassifier(ClassifierFN(func(ctx context.Context) Classification { return "PARENT" })),
+	)
+	child := NewShedder(
+		OptionShedderParent(parent),
+		OptionShedderRejectionRate(childRate),
+		OptionShedderClassifier(ClassifierFN(func(ctx context.Context) Classification { return "CHILD" })),
+	)
+	ctx := context.Background()
+	var class Classification
+	fn := func(ctx context.Context) error {
+		class = ClassificationFromContext(ctx)
+		return nil
+	}
+
+	err := child.Do(ctx, fn)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if class != "CHILD" {
+		t.Fatalf("expected classification %s but got %s", "CHILD", class)
+	}
+}
+
+func TestShedderParentObserverChildRejection(t *testing.T) {
+	t.Parallel()
+
+	obs := &recordingObserver{}
+	parent := NewShedder(
+		OptionShedderObserver(obs),
+	)
+	child := NewShedder(
+		OptionShedderParent(parent),
+		OptionShedderRule(&staticRule{reject: true}),
+	)
+	ctx := context.Background()
+	fn := func(ctx context.Context) error {
+		return nil
+	}
+
+	if err := child.Do(ctx, fn); err == nil {
+		t.Fatal("expected a rejection from the child")
+	}
+	if err := child.Select(ctx); err == nil {
+		t.Fatal("expected a rejection from the child")
+	}
+	if obs.admits != 0 {
+		t.Fatalf("expected %d parent admits but got %d", 0, obs.admits)
+	}
+	if len(obs.completions) != 0 {
+		t.Fatalf("expected %d parent completions but got %d", 0, len(obs.completions))
+	}
+}
+
+func TestShedderChildRejectionPath(t *testing.T) {
+	t.Parallel()
+
+	global := NewShedder(
+		OptionShedderName("global"),
+	)
+	api := NewShedder(
+		OptionShedderParent(global),
+		OptionShedderName("api"),
+	)
+	unnamed := NewShedder(
+		OptionShedderParent(api),
+	)
+	db := NewShedder(
+		OptionShedderParent(unnamed),
+		OptionShedderName("db"),
 		OptionShedderRule(&staticRule{reject: true}),
-		OptionShedderClassifier(ClassifierFN(func(ctx context.Context) Classification { return cls })),
 	)
+	err := db.Do(context.Background(), func(ctx context.Context) error {
+		return nil
+	})
+	var e ErrRejection
+	if !errors.As(err, &e) {
+		t.Fatalf("expected ErrRejection but got %v", err)
+	}
+	if e.Path != "global/api/db" {
+		t.Fatalf("expected path %s but got %s", "global/api/db", e.Path)
+	}
+}
+
+func TestShedderParentWrapsOnce(t *testing.T) {
+	t.Parallel()
 
+	parentRate := &staticCountingRate{value: 0}
+	childRate := &staticCountingRate{value: 0}
+	parentObs := &recordingObserver{}
+	parent := NewShedder(
+		OptionShedderRejectionRate(parentRate),
+		OptionShedderObserver(parentObs),
+	)
+	child := NewShedder(
+		OptionShedderParent(parent),
+		OptionShedderRejectionRate(childRate),
+	)
 	ctx := context.Background()
 	fn := func(ctx context.Context) error {
 		return nil
 	}
-	err := shed.Do(ctx, fn)
-	if err == nil {
-		t.Fatal("expected ErrRejection but got nil")
+
+	_ = child.Do(ctx, fn)
+	_ = child.WrapSelect(fn)(ctx)
+	if parentRate.count != 2 {
+		t.Fatalf("expected %d calls to the parent wrapper but got %d", 2, parentRate.count)
+	}
+	if childRate.count != 2 {
+		t.Fatalf("expected %d calls to the child wrapper but got %d", 2, childRate.count)
+	}
+	if parentObs.admits != 2 || len(parentObs.completions) != 2 {
+		t.Fatalf("expected %d parent admits and completions but got %d and %d", 2, parentObs.admits, len(parentObs.completions))
+	}
+}
+
+func TestShedderParentUpdateDuringInvocation(t *testing.T) {
+	t.Parallel()
+
+	firstObs := &recordingObserver{}
+	secondObs := &recordingObserver{}
+	parent := NewShedder(
+		OptionShedderObserver(firstObs),
+	)
+	child := NewShedder(
+		OptionShedderParent(parent),
+		// The parent is updated after it has made its decision but before the
+		// Fn is wrapped.
+		OptionShedderRule(&hookRule{hook: func() {
+			parent.Update(OptionShedderObserver(secondObs))
+		}}),
+	)
+	ctx := context.Background()
+
+	if err := child.Do(ctx, func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if firstObs.admits != 1 || len(firstObs.completions) != 1 {
+		t.Fatalf("expected %d admits and completions but got %d and %d", 1, firstObs.admits, len(firstObs.completions))
+	}
+	if secondObs.admits != 0 || len(secondObs.completions) != 0 {
+		t.Fatalf("expected no notifications for the new policy but got %d admits and %d completions", secondObs.admits, len(secondObs.completions))
+	}
+}
+
+func TestShedderWrapSelectParentUpdate(t *testing.T) {
+	t.Parallel()
+
+	first := &staticCountingRate{value: 0}
+	second := &staticCountingRate{value: 0}
+	parent := NewShedder(
+		OptionShedderRejectionRate(first),
+	)
+	child := NewShedder(
+		OptionShedderParent(parent),
+	)
+	ctx := context.Background()
+	fn := child.WrapSelect(func(ctx context.Context) error {
+		return nil
+	})
+
+	_ = fn(ctx)
+	parent.Update(
+		OptionShedderRejectionRate(second),
+	)
+	_ = fn(ctx)
+	if first.count != 1 {
+		t.Fatalf("expected %d calls to the first wrapper but got %d", 1, first.count)
+	}
+	if second.count != 1 {
+		t.Fatalf("expected %d calls to the second wrapper but got %d", 1, second.count)
+	}
+}
+
+func TestShedderWrapSelectShadowUpdate(t *testing.T) {
+	t.Parallel()
+
+	shadowObs := &recordingObserver{}
+	shadow := NewShedder(
+		OptionShedderObserver(shadowObs),
+	)
+	shed := NewShedder(
+		OptionShedderShadow(shadow),
+	)
+	ctx := context.Background()
+	fn := shed.WrapSelect(func(ctx context.Context) error {
+		return nil
+	})
+
+	_ = fn(ctx)
+	shadow.Update(
+		OptionShedderRule(&staticRule{reject: true}),
+		OptionShedderObserver(shadowObs),
+	)
+	if err := fn(ctx); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if shadowObs.admits != 1 {
+		t.Fatalf("expected %d shadow admits but got %d", 1, shadowObs.admits)
+	}
+	if len(shadowObs.rejects) != 1 {
+		t.Fatalf("expected %d shadow rejections but got %d", 1, len(shadowObs.rejects))
+	}
+}
+
+func TestShedderAcquire(t *testing.T) {
+	t.Parallel()
+
+	conc := NewCapacityConcurrency(2)
+	errRate := NewCapacityErrorRate()
+	obs := &recordingObserver{}
+	shed := NewShedder(
+		OptionShedderRejectionRate(NewRejectionRateCurveIdentity(NewFailureProbabilityCurveIdentity(conc))),
+		OptionShedderRejectionRate(NewRejectionRateCurveIdentity(NewFailureProbabilityCurveIdentity(errRate))),
+		OptionShedderClassifier(ClassifierFN(func(ctx context.Context) Classification { return "HIGH" })),
+		OptionShedderObserver(obs),
+		OptionShedderRandom(fixedRandom(.99)),
+	)
+	ctx := context.Background()
+
+	ticket, err := shed.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if c := ClassificationFromContext(ticket.Context()); c != "HIGH" {
+		t.Fatalf("expected classification %s but got %s", "HIGH", c)
+	}
+	if u := conc.Usage(ctx); u != .5 {
+		t.Fatalf("expected usage %f but got %f", .5, u)
+	}
+	e := errors.New("TEST")
+	ticket.Release(e)
+	ticket.Release(nil)
+	if u := conc.Usage(ctx); u != 0 {
+		t.Fatalf("expected usage %f but got %f", 0.0, u)
+	}
+	if u := errRate.Usage(ctx); u != 1 {
+		t.Fatalf("expected error rate %f but got %f", 1.0, u)
+	}
+	if len(obs.completions) != 1 || obs.completions[0] != e {
+		t.Fatalf("expected a single completion with the release error but got %v", obs.completions)
 	}
+}
+
+func TestShedderAcquireRejection(t *testing.T) {
+	t.Parallel()
+
+	shed := NewShedder(
+		OptionShedderRule(&staticRule{reject: true}),
+	)
+	ticket, err := shed.Acquire(context.Background())
 	var e ErrRejection
 	if !errors.As(err, &e) {
-		t.Fatalf("expected ErrRejection but got %s", err)
+		t.Fatalf("expected ErrRejection but got %v", err)
 	}
-	if e.Rule != nameStatic {
-		t.Fatalf("expected rule %s but got %s", nameStatic, e.Rule)
+	if ticket != nil {
+		t.Fatal("expected no ticket for a rejection")
 	}
-	if e.Classification != cls {
-		t.Fatalf("expected classification %s but got %s", cls, e.Classification)
+}
+
+func TestShedderAcquireWrapperRejection(t *testing.T) {
+	t.Parallel()
+
+	q := NewCapacityQueue(NewCapacityConcurrency(1), 0)
+	shed := NewShedder(
+		OptionShedderRejectionRate(NewRejectionRateCurveIdentity(NewFailureProbabilityCurveIdentity(q))),
+		OptionShedderRandom(fixedRandom(.99)),
+	)
+	ctx := context.Background()
+	first, err := shed.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	defer first.Release(nil)
+	_, err = shed.Acquire(ctx)
+	var e ErrRejection
+	if !errors.As(err, &e) {
+		t.Fatalf("expected ErrRejection but got %v", err)
+	}
+	if e.Rule != RuleQueueFull {
+		t.Fatalf("expected rule %s but got %s", RuleQueueFull, e.Rule)
+	}
+}
+
+func TestShedderSelectN(t *testing.T) {
+	t.Parallel()
+
+	rate := &classRate{rates: map[Classification]float32{"LOW": 1}}
+	obs := &recordingObserver{}
+	shed := NewShedder(
+		OptionShedderRejectionRate(rate),
+		OptionShedderObserver(obs),
+	)
+	classes := []Classification{"LOW", "HIGH", "LOW", "HIGH", "LOW"}
+	results := shed.SelectN(context.Background(), classes)
+	if len(results) != len(classes) {
+		t.Fatalf("expected %d results but got %d", len(classes), len(results))
+	}
+	for offset, class := range classes {
+		if class == "HIGH" {
+			if results[offset] != nil {
+				t.Fatalf("got unexpected error for item %d: %s", offset, results[offset])
+			}
+			continue
+		}
+		var e ErrRejection
+		if !errors.As(results[offset], &e) {
+			t.Fatalf("expected ErrRejection for item %d but got %v", offset, results[offset])
+		}
+		if e.Classification != class {
+			t.Fatalf("expected classification %s but got %s", class, e.Classification)
+		}
+	}
+	if rate.calls != 2 {
+		t.Fatalf("expected %d rate calculations but got %d", 2, rate.calls)
+	}
+	if obs.admits != 2 || len(obs.rejects) != 3 {
+		t.Fatalf("expected %d admits and %d rejects but got %d and %d", 2, 3, obs.admits, len(obs.rejects))
+	}
+}
+
+func TestShedderSelectNParent(t *testing.T) {
+	t.Parallel()
+
+	parent := NewShedder(
+		OptionShedderName("parent"),
+		OptionShedderRejectionRate(&classRate{rates: map[Classification]float32{"LOW": 1}}),
+	)
+	child := NewShedder(
+		OptionShedderName("child"),
+		OptionShedderParent(parent),
+		OptionShedderRejectionRate(&classRate{rates: map[Classification]float32{"NORMAL": 1}}),
+	)
+	results := child.SelectN(context.Background(), []Classification{"LOW", "NORMAL", "HIGH"})
+	var e ErrRejection
+	if !errors.As(results[0], &e) || e.Path != "parent" {
+		t.Fatalf("expected a rejection from the parent but got %v", results[0])
+	}
+	if !errors.As(results[1], &e) || e.Path != "parent/child" {
+		t.Fatalf("expected a rejection from the child but got %v", results[1])
+	}
+	if results[2] != nil {
+		t.Fatalf("got unexpected error: %s", results[2])
+	}
+}
+
+func TestShedderDoN(t *testing.T) {
+	t.Parallel()
+
+	conc := NewCapacityConcurrency(10)
+	shed := NewShedder(
+		OptionShedderRejectionRate(NewRejectionRateCurveByClassification(
+			NewFailureProbabilityCurveIdentity(conc),
+			CurveFN(func(ctx context.Context, value float32) float32 { return 0 }),
+			map[Classification]Curve{"LOW": CurveFN(func(ctx context.Context, value float32) float32 { return 1 })},
+		)),
+	)
+	var usage float32
+	var admitted []int
+	results, err := shed.DoN(context.Background(), []Classification{"LOW", "HIGH", "HIGH", "LOW"}, func(ctx context.Context, a []int, _ []bool) error {
+		usage = conc.Usage(ctx)
+		admitted = a
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if len(admitted) != 2 || admitted[0] != 1 || admitted[1] != 2 {
+		t.Fatalf("expected admitted items %v but got %v", []int{1, 2}, admitted)
+	}
+	if results[0] == nil || results[3] == nil {
+		t.Fatal("expected LOW items to be rejected")
+	}
+	if usage != .2 {
+		t.Fatalf("expected usage %f but got %f", .2, usage)
+	}
+}
+
+func TestShedderDoNShadowRejection(t *testing.T) {
+	t.Parallel()
+
+	fn := func(ctx context.Context, admitted []int, _ []bool) error {
+		return nil
+	}
+	ctx := context.Background()
+
+	allObs := &recordingObserver{}
+	shed := NewShedder(
+		OptionShedderShadow(NewShedder(
+			OptionShedderRule(&staticRule{reject: true}),
+			OptionShedderObserver(allObs),
+		)),
+	)
+	if _, err := shed.DoN(ctx, []Classification{"", "", ""}, fn); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if allObs.admits != 0 || len(allObs.rejects) != 3 || len(allObs.completions) != 0 {
+		t.Fatalf("expected %d admits, %d rejections, and %d completions but got %d, %d, and %d", 0, 3, 0, allObs.admits, len(allObs.rejects), len(allObs.completions))
+	}
+
+	someObs := &recordingObserver{}
+	parentObs := &recordingObserver{}
+	shed = NewShedder(
+		OptionShedderShadow(NewShedder(
+			OptionShedderParent(NewShedder(OptionShedderObserver(parentObs))),
+			OptionShedderRejectionRate(&classRate{rates: map[Classification]float32{"LOW": 1}}),
+			OptionShedderObserver(someObs),
+		)),
+	)
+	if _, err := shed.DoN(ctx, []Classification{"LOW", "HIGH", "LOW"}, fn); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if someObs.admits != 1 || len(someObs.rejects) != 2 || len(someObs.completions) != 1 {
+		t.Fatalf("expected %d admits, %d rejections, and %d completions but got %d, %d, and %d", 1, 2, 1, someObs.admits, len(someObs.rejects), len(someObs.completions))
+	}
+	if parentObs.admits != 1 {
+		t.Fatalf("expected %d parent admits but got %d", 1, parentObs.admits)
+	}
+}
+
+func TestShedderDoNNoneAdmitted(t *testing.T) {
+	t.Parallel()
+
+	shed := NewShedder(
+		OptionShedderRule(&staticRule{reject: true}),
+	)
+	called := false
+	_, err := shed.DoN(context.Background(), []Classification{"", ""}, func(ctx context.Context, admitted []int, _ []bool) error {
+		called = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if called {
+		t.Fatal("expected the function to not be called")
+	}
+}
+
+func TestShedderDoSingleEvaluation(t *testing.T) {
+	t.Parallel()
+
+	capacity := &countingCapacity{values: []float32{.9, .1}}
+	obs := &recordingObserver{}
+	shed := NewShedder(
+		OptionShedderRejectionRate(NewRejectionRateCurveIdentity(NewFailureProbabilityCurveIdentity(capacity))),
+		OptionShedderObserver(obs),
+		OptionShedderRandom(fixedRandom(.5)),
+	)
+	err := shed.Do(context.Background(), func(ctx context.Context) error {
+		return nil
+	})
+	var e ErrRejection
+	if !errors.As(err, &e) {
+		t.Fatalf("expected ErrRejection but got %v", err)
+	}
+	if capacity.calls != 1 {
+		t.Fatalf("expected %d usage calculations but got %d", 1, capacity.calls)
+	}
+	if e.Usage != .9 || e.Likelihood != .9 || e.Rate != .9 {
+		t.Fatalf("expected the values that caused the rejection but got %v", e)
+	}
+	if e.Roll != .5 {
+		t.Fatalf("expected roll %f but got %f", .5, e.Roll)
+	}
+	if len(obs.rejects) != 1 || obs.rejects[0].Usage != .9 {
+		t.Fatalf("expected observers to receive the same decision but got %v", obs.rejects)
+	}
+}
+
+func TestShedderObserverAdmitDecisions(t *testing.T) {
+	t.Parallel()
+
+	obs := &recordingObserver{}
+	shed := NewShedder(
+		OptionShedderRejectionRate(&staticCountingRate{value: .2}),
+		OptionShedderObserver(obs),
+		OptionShedderRandom(fixedRandom(.5)),
+	)
+	ctx := context.Background()
+	expected := Decision{Name: nameStatic, Usage: .2, Likelihood: .2, Rate: .2, Roll: .5}
+
+	if err := shed.Do(ctx, func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if err := shed.Select(ctx); err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	_, err := shed.DoN(ctx, []Classification{"", ""}, func(ctx context.Context, admitted []int, _ []bool) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("got unexpected error: %s", err)
+	}
+	if len(obs.decisions) != 4 {
+		t.Fatalf("expected %d admits but got %d", 4, len(obs.decisions))
+	}
+	for _, decisions := range obs.decisions {
+		if len(decisions) != 1 || decisions[0] != expected {
+			t.Fatalf("expected decisions %v but got %v", []Decision{expected}, decisions)
+		}
 	}
 }
 
@@ -153,6 +1252,24 @@ func BenchmarkShedderDoRate(b *testing.B) {
 	}
 }
 
+// BenchmarkShedderDoAdmit measures the path of an admitted invocation through a
+// Shedder without any observer, shadow, or parent. None of the state needed by
+// those features should be allocated.
+func BenchmarkShedderDoAdmit(b *testing.B) {
+	shed := NewShedder(
+		OptionShedderRejectionRate(&staticCountingRate{value: 0}),
+	)
+	ctx := context.Background()
+	fn := func(ctx context.Context) error {
+		return nil
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n = n + 1 {
+		shedErr = shed.Do(ctx, fn)
+	}
+}
+
 func BenchmarkShedderWrapPlusSelect(b *testing.B) {
 	shed := NewShedder(
 		OptionShedderRejectionRate(&staticCountingRate{value: .5}),
@@ -198,6 +1315,26 @@ func (self *staticCountingRate) Wrap(fn Fn) Fn {
 	}
 }
 
+type recordingObserver struct {
+	admits      int
+	decisions   [][]Decision
+	rejects     []ErrRejection
+	completions []error
+}
+
+func (self *recordingObserver) Admit(_ context.Context, decisions []Decision) {
+	self.admits = self.admits + 1
+	self.decisions = append(self.decisions, decisions)
+}
+
+func (self *recordingObserver) Reject(_ context.Context, err ErrRejection) {
+	self.rejects = append(self.rejects, err)
+}
+
+func (self *recordingObserver) Complete(_ context.Context, _ time.Duration, err error) {
+	self.completions = append(self.completions, err)
+}
+
 type staticRule struct {
 	reject bool
 }
@@ -208,3 +1345,79 @@ func (self *staticRule) Name(ctx context.Context) string {
 func (self *staticRule) Reject(ctx context.Context) bool {
 	return self.reject
 }
+
+// hookRule never rejects and calls the hook each time it is consulted.
+type hookRule struct {
+	hook func()
+}
+
+func (*hookRule) Name(context.Context) string {
+	return nameStatic
+}
+
+func (self *hookRule) Reject(context.Context) bool {
+	self.hook()
+	return false
+}
+
+// wrapperRule never rejects by its decision but rejects every invocation from
+// its wrapper.
+type wrapperRule struct{}
+
+func (*wrapperRule) Name(context.Context) string {
+	return nameStatic
+}
+
+func (*wrapperRule) Reject(context.Context) bool {
+	return false
+}
+
+func (*wrapperRule) Wrap(fn Fn) Fn {
+	return func(ctx context.Context) error {
+		return ErrRejection{Rule: nameStatic, Classification: ClassificationFromContext(ctx)}
+	}
+}
+
+// classRate reports a fixed rate for each classification and counts the
+// number of times the rate is calculated.
+type classRate struct {
+	rates map[Classification]float32
+	calls int
+}
+
+func (*classRate) Name(context.Context) string {
+	return nameStatic
+}
+
+func (self *classRate) Usage(ctx context.Context) float32 {
+	return self.rates[ClassificationFromContext(ctx)]
+}
+
+func (self *classRate) Likelihood(ctx context.Context) float32 {
+	return self.rates[ClassificationFromContext(ctx)]
+}
+
+func (self *classRate) Rate(ctx context.Context) float32 {
+	self.calls = self.calls + 1
+	return self.rates[ClassificationFromContext(ctx)]
+}
+
+// countingCapacity reports each of its values in order, repeating the final
+// value, and counts the number of times the usage is calculated.
+type countingCapacity struct {
+	values []float32
+	calls  int
+}
+
+func (*countingCapacity) Name(context.Context) string {
+	return nameStatic
+}
+
+func (self *countingCapacity) Usage(context.Context) float32 {
+	offset := self.calls
+	if offset >= len(self.values) {
+		offset = len(self.values) - 1
+	}
+	self.calls = self.calls + 1
+	return self.values[offset]
+}
@@ -0,0 +1,43 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import "context"
+
+// FnValue is a variant of Fn that produces a value in addition to an error.
+type FnValue[T any] func(context.Context) (T, error)
+
+// DoValue is equivalent to calling Do on the Shedder except that the value
+// produced by the function is returned to the caller. The zero value of T is
+// returned if the function is not executed.
+func DoValue[T any](ctx context.Context, shed *Shedder, fn FnValue[T]) (T, error) {
+	var result T
+	err := shed.Do(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = fn(ctx)
+		return err
+	})
+	return result, err
+}
+
+// WrapValue is equivalent to calling WrapSelect on the Shedder except that the
+// returned function produces the value of the given function. The zero value
+// of T is returned if the function is not executed.
+func WrapValue[T any](shed *Shedder, fn FnValue[T]) FnValue[T] {
+	wrapped := shed.WrapSelect(func(ctx context.Context) error {
+		slot := ctx.Value(valueCtxKey[T]{}).(*T)
+		var err error
+		*slot, err = fn(ctx)
+		return err
+	})
+	return func(ctx context.Context) (T, error) {
+		var result T
+		err := wrapped(context.WithValue(ctx, valueCtxKey[T]{}, &result))
+		return result, err
+	}
+}
+
+// valueCtxKey carries the destination of the value produced by a function
+// given to WrapValue so that the wrapped function can be re-used.
+type valueCtxKey[T any] struct{}
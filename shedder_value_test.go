@@ -0,0 +1,75 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestDoValue(t *testing.T) {
+	t.Parallel()
+
+	rate := &staticCountingRate{value: 0}
+	shed := NewShedder(
+		OptionShedderRejectionRate(rate),
+	)
+	ctx := context.Background()
+	e := errors.New("TEST")
+	v, err := DoValue(ctx, shed, func(ctx context.Context) (int, error) {
+		return 42, e
+	})
+	if err != e {
+		t.Fatalf("expected error %v but got %v", e, err)
+	}
+	if v != 42 {
+		t.Fatalf("expected value %d but got %d", 42, v)
+	}
+	if rate.count != 1 {
+		t.Fatalf("expected %d wrapper calls but got %d", 1, rate.count)
+	}
+}
+
+func TestDoValueRejection(t *testing.T) {
+	t.Parallel()
+
+	shed := NewShedder(
+		OptionShedderRule(&staticRule{reject: true}),
+	)
+	v, err := DoValue(context.Background(), shed, func(ctx context.Context) (string, error) {
+		return "value", nil
+	})
+	var e ErrRejection
+	if !errors.As(err, &e) {
+		t.Fatalf("expected ErrRejection but got %v", err)
+	}
+	if v != "" {
+		t.Fatalf("expected the zero value but got %s", v)
+	}
+}
+
+func TestWrapValue(t *testing.T) {
+	t.Parallel()
+
+	rate := &staticCountingRate{value: 0}
+	shed := NewShedder(
+		OptionShedderRejectionRate(rate),
+	)
+	fn := WrapValue(shed, func(ctx context.Context) (int, error) {
+		return CostFromContext(ctx), nil
+	})
+	for x := 1; x <= 3; x = x + 1 {
+		v, err := fn(CostToContext(context.Background(), x))
+		if err != nil {
+			t.Fatalf("got unexpected error: %s", err)
+		}
+		if v != x {
+			t.Fatalf("expected value %d but got %d", x, v)
+		}
+	}
+	if rate.count != 3 {
+		t.Fatalf("expected %d wrapper calls but got %d", 3, rate.count)
+	}
+}
@@ -0,0 +1,168 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+type OptionSlowStart func(*SlowStart)
+
+// OptionSlowStartInitial sets the fraction of the full limit that is allowed
+// when the slow start begins. The default is .1.
+func OptionSlowStartInitial(fraction float32) OptionSlowStart {
+	return func(ss *SlowStart) {
+		ss.initial = fraction
+	}
+}
+
+// OptionSlowStartLatency ends the slow start early once the latency reported
+// by the CapacityLatency is at or below the target. Latency is only considered
+// once at least one measurement is available.
+func OptionSlowStartLatency(latency *CapacityLatency, target time.Duration) OptionSlowStart {
+	return func(ss *SlowStart) {
+		ss.latency = latency
+		ss.target = target
+	}
+}
+
+// SlowStart manages a warm-up period for a freshly started process. The
+// effective limit starts at a fraction of the full limit and increases
+// linearly to the full limit over a duration. Once the full limit is reached
+// the slow start is complete and does not begin again.
+//
+// SlowStart is applied by either NewRuleSlowStart or NewCapacitySlowStart.
+type SlowStart struct {
+	start    time.Time
+	duration time.Duration
+	initial  float32
+	latency  *CapacityLatency
+	target   time.Duration
+	done     *atomic.Bool
+	now      func() time.Time
+}
+
+// NewSlowStart begins a slow start that lasts for the given duration.
+func NewSlowStart(duration time.Duration, options ...OptionSlowStart) *SlowStart {
+	s := &SlowStart{
+		start:    time.Now(),
+		duration: duration,
+		initial:  defaultSlowStartInitial,
+		done:     &atomic.Bool{},
+		now:      time.Now,
+	}
+	for _, opt := range options {
+		opt(s)
+	}
+	return s
+}
+
+// Factor returns the fraction of the full limit that is currently allowed.
+// The value is 1 once the slow start is complete.
+func (self *SlowStart) Factor(ctx context.Context) float32 {
+	if self.done.Load() {
+		return 1
+	}
+	if self.latency != nil {
+		if l := self.latency.Latency(ctx); l > 0 && l <= self.target {
+			self.done.Store(true)
+			return 1
+		}
+	}
+	elapsed := self.now().Sub(self.start)
+	if elapsed >= self.duration {
+		self.done.Store(true)
+		return 1
+	}
+	return self.initial + (1-self.initial)*float32(float64(elapsed)/float64(self.duration))
+}
+
+// Done returns true once the slow start is complete.
+func (self *SlowStart) Done(ctx context.Context) bool {
+	return self.Factor(ctx) >= 1
+}
+
+// RuleSlowStart rejects invocations while the usage of a Capacity exceeds the
+// current SlowStart factor. For example, a CapacityConcurrency with a limit of
+// 100 and a factor of .1 rejects any invocation while 10 or more are already
+// running.
+//
+// The Wrapper of the Capacity, if any, is applied to admitted invocations so
+// the Capacity should not also be installed elsewhere in the same Shedder. Use
+// CapacitySlowStart to apply a SlowStart to a Capacity that is used by a
+// RejectionRate.
+type RuleSlowStart struct {
+	name     string
+	slow     *SlowStart
+	capacity Capacity
+}
+
+func NewRuleSlowStart(slow *SlowStart, capacity Capacity) *RuleSlowStart {
+	return &RuleSlowStart{
+		name:     defaultNameSlowStart,
+		slow:     slow,
+		capacity: capacity,
+	}
+}
+
+func (self *RuleSlowStart) Name(context.Context) string {
+	return self.name
+}
+
+func (self *RuleSlowStart) Reject(ctx context.Context) bool {
+	factor := self.slow.Factor(ctx)
+	if factor >= 1 {
+		return false
+	}
+	return self.capacity.Usage(ctx) >= factor
+}
+
+func (self *RuleSlowStart) Wrap(fn Fn) Fn {
+	if w, ok := self.capacity.(Wrapper); ok {
+		return w.Wrap(fn)
+	}
+	return fn
+}
+
+// CapacitySlowStart is a wrapper for other Capacity implementations that
+// scales the limit of the Capacity by the current SlowStart factor. The usage
+// of the wrapped Capacity is divided by the factor so that, for example, a
+// CapacityLandingRate with a limit of 1000 behaves as if the limit were 100
+// while the factor is .1.
+type CapacitySlowStart struct {
+	Capacity
+	slow *SlowStart
+}
+
+func NewCapacitySlowStart(slow *SlowStart, capacity Capacity) *CapacitySlowStart {
+	return &CapacitySlowStart{
+		Capacity: capacity,
+		slow:     slow,
+	}
+}
+
+func (self *CapacitySlowStart) Usage(ctx context.Context) float32 {
+	return self.Capacity.Usage(ctx) / self.slow.Factor(ctx)
+}
+
+func (self *CapacitySlowStart) Wrap(fn Fn) Fn {
+	if w, ok := self.Capacity.(Wrapper); ok {
+		return w.Wrap(fn)
+	}
+	return fn
+}
+
+func (self *CapacitySlowStart) RetryAfter(ctx context.Context) time.Duration {
+	return retryAfter(ctx, self.Capacity)
+}
+
+const defaultNameSlowStart string = "SLOW START"
+const defaultSlowStartInitial float32 = .1
+
+var _ Rule = &RuleSlowStart{}
+var _ Wrapper = &RuleSlowStart{}
+var _ Capacity = &CapacitySlowStart{}
+var _ Wrapper = &CapacitySlowStart{}
@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package loadshed
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSlowStartFactor(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s := NewSlowStart(10*time.Second, OptionSlowStartInitial(.2))
+	now := s.start
+	s.now = func() time.Time { return now }
+
+	if f := s.Factor(ctx); f != .2 {
+		t.Fatalf("expected factor %f but got %f", .2, f)
+	}
+	now = now.Add(5 * time.Second)
+	if f := s.Factor(ctx); f < .599 || f > .601 {
+		t.Fatalf("expected factor %f but got %f", .6, f)
+	}
+	now = now.Add(5 * time.Second)
+	if !s.Done(ctx) {
+		t.Fatal("expected the slow start to be done")
+	}
+	now = s.start
+	if f := s.Factor(ctx); f != 1 {
+		t.Fatalf("expected the slow start to remain done but got factor %f", f)
+	}
+}
+
+func TestSlowStartLatency(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	latency := NewCapacityLatency(time.Second)
+	s := NewSlowStart(time.Hour, OptionSlowStartLatency(latency, 10*time.Millisecond))
+	if s.Done(ctx) {
+		t.Fatal("expected the slow start to not be done without latency data")
+	}
+	latency.Append(ctx, 50*time.Millisecond)
+	if s.Done(ctx) {
+		t.Fatal("expected the slow start to not be done while latency is above target")
+	}
+	for x := 0; x < 10; x = x + 1 {
+		latency.Append(ctx, time.Millisecond)
+	}
+	if !s.Done(ctx) {
+		t.Fatal("expected the slow start to be done once latency settled")
+	}
+}
+
+func TestRuleSlowStart(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s := NewSlowStart(time.Hour, OptionSlowStartInitial(.5))
+	conc := NewCapacityConcurrency(4)
+	r := NewRuleSlowStart(s, conc)
+
+	conc.Add(1)
+	if r.Reject(ctx) {
+		t.Fatal("expected no rejection below the scaled limit")
+	}
+	conc.Add(1)
+	if !r.Reject(ctx) {
+		t.Fatal("expected a rejection at the scaled limit")
+	}
+	s.done.Store(true)
+	if r.Reject(ctx) {
+		t.Fatal("expected no rejection after the slow start")
+	}
+}
+
+func TestCapacitySlowStart(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	s := NewSlowStart(time.Hour, OptionSlowStartInitial(.5))
+	landing := NewCapacityLandingRate(10)
+	c := NewCapacitySlowStart(s, landing)
+	_ = c.Wrap(func(ctx context.Context) error { return nil })(ctx)
+	_ = c.Wrap(func(ctx context.Context) error { return nil })(ctx)
+	if u := c.Usage(ctx); u < .399 || u > .401 {
+		t.Fatalf("expected usage %f but got %f", .4, u)
+	}
+}
@@ -19,7 +19,9 @@ type HandlerOption func(*HandlerMiddleware) *HandlerMiddleware
 
 // HandlerOptionCallback adds a callback to the middleware that is invoked
 // each time the load shedder rejects a request. This can be used to collect
-// load shedder metrics or to respond with a custom status and message.
+// load shedder metrics or to respond with a custom status and message. The
+// default callback responds with a 503 status and a Retry-After header when
+// the rejection includes a RetryAfter hint.
 func HandlerOptionCallback(cb http.Handler) HandlerOption {
 	return func(m *HandlerMiddleware) *HandlerMiddleware {
 		m.callback = cb
@@ -75,7 +77,10 @@ func (m *HandlerMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// defaultCallback responds with a 503 and, if the rejection included a hint,
+// a Retry-After header.
 func defaultCallback(w http.ResponseWriter, r *http.Request) {
+	setRetryAfter(w.Header(), FromHandlerContext(r.Context()).RetryAfter)
 	w.WriteHeader(http.StatusServiceUnavailable)
 }
 
@@ -46,6 +46,26 @@ func TestHandlerWithShedding(t *testing.T) {
 	}
 }
 
+func TestHandlerRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	shed := loadshed.NewShedder(
+		loadshed.OptionShedderRule(&staticRule{reject: true, retry: 1500 * time.Millisecond}),
+	)
+	middleware := NewHandlerMiddleware(shed)
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("middleware did not shed load. status code: %d", w.Code)
+	}
+	if v := w.Header().Get("Retry-After"); v != "2" {
+		t.Fatalf("expected Retry-After of 2 but got %q", v)
+	}
+}
+
 func TestHandlerCallback(t *testing.T) {
 	t.Parallel()
 
@@ -117,6 +137,7 @@ const nameStatic = "static"
 
 type staticRule struct {
 	reject bool
+	retry  time.Duration
 }
 
 func (self *staticRule) Name(ctx context.Context) string {
@@ -125,3 +146,7 @@ func (self *staticRule) Name(ctx context.Context) string {
 func (self *staticRule) Reject(ctx context.Context) bool {
 	return self.reject
 }
+
+func (self *staticRule) RetryAfter(ctx context.Context) time.Duration {
+	return self.retry
+}
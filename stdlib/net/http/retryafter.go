@@ -0,0 +1,22 @@
+// SPDX-FileCopyrightText: © 2024 Kevin Conway
+// SPDX-License-Identifier: Apache-2.0
+
+package http
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// setRetryAfter adds a Retry-After header, rounded up to whole seconds, if the
+// hint is set and the header is not already present.
+func setRetryAfter(h http.Header, d time.Duration) {
+	if d <= 0 || h.Get(headerRetryAfter) != "" {
+		return
+	}
+	h.Set(headerRetryAfter, strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10))
+}
+
+const headerRetryAfter = "Retry-After"
@@ -32,7 +32,9 @@ func TransportOptionErrorCodes(errorCodes []int) TransportOption {
 }
 
 // TransportOptionCallback sets the load shedding callback. This enables custom
-// behavior when a request is rejected.
+// behavior when a request is rejected. If the callback returns a response
+// without a Retry-After header and the rejection includes a RetryAfter hint
+// then the header is added to the response.
 func TransportOptionCallback(cb func(*http.Request) (*http.Response, error)) TransportOption {
 	return func(t *TransportMiddleware) *TransportMiddleware {
 		t.callback = cb
@@ -49,11 +51,8 @@ type TransportMiddleware struct {
 }
 
 func (c *TransportMiddleware) RoundTrip(r *http.Request) (*http.Response, error) {
-	var resp *http.Response
-	var e = c.load.Do(r.Context(), func(ctx context.Context) error {
-		var innerResp, innerEr = c.wrapped.RoundTrip(r.WithContext(ctx)) //nolint:bodyclose
-		resp = innerResp
-		return innerEr
+	var resp, e = loadshed.DoValue(r.Context(), c.load, func(ctx context.Context) (*http.Response, error) {
+		return c.wrapped.RoundTrip(r.WithContext(ctx)) //nolint:bodyclose
 	})
 
 	status := &errStatusCode{}
@@ -68,7 +67,14 @@ func (c *TransportMiddleware) RoundTrip(r *http.Request) (*http.Response, error)
 	if errors.As(e, &shed) {
 		if c.callback != nil {
 			r = r.WithContext(NewTransportContext(r.Context(), shed))
-			return c.callback(r)
+			cbResp, cbErr := c.callback(r)
+			if cbResp != nil {
+				if cbResp.Header == nil {
+					cbResp.Header = http.Header{}
+				}
+				setRetryAfter(cbResp.Header, shed.RetryAfter)
+			}
+			return cbResp, cbErr
 		}
 	}
 	return resp, e
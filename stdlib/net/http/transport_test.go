@@ -217,6 +217,33 @@ func TestTransportOptionCallback(t *testing.T) {
 	}
 }
 
+func TestTransportOptionCallbackRetryAfter(t *testing.T) {
+	t.Parallel()
+
+	load := loadshed.NewShedder(
+		loadshed.OptionShedderRule(&staticRule{reject: true, retry: 3 * time.Second}),
+	)
+	var hint time.Duration
+	cb := func(r *http.Request) (*http.Response, error) {
+		hint = FromTransportContext(r.Context()).RetryAfter
+		return &http.Response{StatusCode: http.StatusServiceUnavailable}, nil
+	}
+	wrapped := &fixtureTransport{Response: &http.Response{StatusCode: 200}, Err: nil}
+	tr := NewTransportMiddleware(load, TransportOptionCallback(cb))(wrapped)
+	req, _ := http.NewRequest("GET", "/", io.NopCloser(bytes.NewReader([]byte(``))))
+
+	res, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %T(%s)", err, err)
+	}
+	if hint != 3*time.Second {
+		t.Fatalf("expected a retry hint of %s but got %s", 3*time.Second, hint)
+	}
+	if v := res.Header.Get("Retry-After"); v != "3" {
+		t.Fatalf("expected Retry-After of 3 but got %q", v)
+	}
+}
+
 func TestTransportLoadshedder(t *testing.T) {
 	t.Parallel()
 